@@ -0,0 +1,35 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+// NonRetryableError indicates that a service reconciliation cannot succeed until
+// the service is changed, so it should not be retried.
+type NonRetryableError struct {
+	msg string
+}
+
+// NewNonRetryableError returns a NonRetryableError.
+func NewNonRetryableError(msg string) *NonRetryableError {
+	return &NonRetryableError{
+		msg: msg,
+	}
+}
+
+// Error shows the reason the reconciliation cannot succeed.
+func (e *NonRetryableError) Error() string {
+	return e.msg
+}
@@ -194,6 +194,14 @@ func Run(c *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface
 	if c.SecureServing != nil {
 		unsecuredMux := genericcontrollermanager.NewBaseHandler(&c.ComponentConfig.Generic.Debugging, healthzHandler)
 
+		// Install any endpoints served by the cloud provider itself
+		if handlerProvider, ok := cloud.(cloudprovider.HTTPHandlerProvider); ok {
+			for path, handler := range handlerProvider.HTTPHandlers() {
+				klog.Infof("Installing cloud provider handler at %q", path)
+				unsecuredMux.Handle(path, handler)
+			}
+		}
+
 		slis.SLIMetricsWithReset{}.Install(unsecuredMux)
 
 		handler := genericcontrollermanager.BuildHandlerChain(unsecuredMux, &c.Authorization, &c.Authentication)
@@ -369,7 +377,10 @@ func ControllerNames(controllerInitFuncConstructors map[string]ControllerInitFun
 
 var (
 	// ControllersDisabledByDefault is the controller disabled default when starting cloud-controller managers.
-	ControllersDisabledByDefault = sets.NewString()
+	// The service load balancer garbage collector deletes cloud load balancers, so it must be enabled explicitly.
+	ControllersDisabledByDefault = sets.NewString(
+		names.ServiceLBGarbageCollectorController,
+	)
 
 	// AllWebhooks represents the list of all webhook options configured in
 	// this package.  This is empty because no webhooks are currently
@@ -422,6 +433,13 @@ func StartServiceControllerWrapper(initContext ControllerInitContext, completedC
 	}
 }
 
+// StartServiceLBGarbageCollectorWrapper is used to take cloud config as input and start service load balancer garbage collector
+func StartServiceLBGarbageCollectorWrapper(initContext ControllerInitContext, completedConfig *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) InitFunc {
+	return func(ctx context.Context, controllerContext genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return startServiceLBGarbageCollector(ctx, initContext, controllerContext, completedConfig, cloud)
+	}
+}
+
 // StartRouteControllerWrapper is used to take cloud config as input and start route controller
 func StartRouteControllerWrapper(initContext ControllerInitContext, completedConfig *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) InitFunc {
 	return func(ctx context.Context, controllerContext genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
@@ -452,6 +470,12 @@ var DefaultInitFuncConstructors = map[string]ControllerInitFuncConstructor{
 		},
 		Constructor: StartServiceControllerWrapper,
 	},
+	names.ServiceLBGarbageCollectorController: {
+		InitContext: ControllerInitContext{
+			ClientName: names.ServiceLBGarbageCollectorController,
+		},
+		Constructor: StartServiceLBGarbageCollectorWrapper,
+	},
 	names.NodeRouteController: {
 		InitContext: ControllerInitContext{
 			ClientName: "route-controller",
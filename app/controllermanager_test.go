@@ -38,12 +38,25 @@ func TestCloudControllerNamesConsistency(t *testing.T) {
 	}
 }
 
+func TestControllersDisabledByDefault(t *testing.T) {
+	// Controllers deleting cloud resources must be enabled explicitly
+	if !ControllersDisabledByDefault.Has(names.ServiceLBGarbageCollectorController) {
+		t.Errorf("controller %q must be disabled by default", names.ServiceLBGarbageCollectorController)
+	}
+	for _, name := range ControllersDisabledByDefault.List() {
+		if _, ok := DefaultInitFuncConstructors[name]; !ok {
+			t.Errorf("controller %q is disabled by default but not known", name)
+		}
+	}
+}
+
 func TestCloudControllerNamesDeclaration(t *testing.T) {
 	declaredControllers := sets.New(
 		names.CloudNodeController,
 		names.ServiceLBController,
 		names.NodeRouteController,
 		names.CloudNodeLifecycleController,
+		names.ServiceLBGarbageCollectorController,
 	)
 
 	for name := range DefaultInitFuncConstructors {
@@ -31,6 +31,7 @@ import (
 	cloudnodelifecyclecontroller "k8s.io/cloud-provider/controllers/nodelifecycle"
 	routecontroller "k8s.io/cloud-provider/controllers/route"
 	servicecontroller "k8s.io/cloud-provider/controllers/service"
+	servicelbgccontroller "k8s.io/cloud-provider/controllers/servicelbgc"
 	controllermanagerapp "k8s.io/controller-manager/app"
 	"k8s.io/controller-manager/controller"
 	"k8s.io/klog/v2"
@@ -99,6 +100,24 @@ func startServiceController(ctx context.Context, initContext ControllerInitConte
 	return nil, true, nil
 }
 
+func startServiceLBGarbageCollector(ctx context.Context, initContext ControllerInitContext, controlexContext controllermanagerapp.ControllerContext, completedConfig *config.CompletedConfig, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	// The garbage collector is optional for cloud providers, skip it if the load balancers cannot be listed
+	garbageCollector, err := servicelbgccontroller.New(
+		cloud,
+		completedConfig.SharedInformers.Core().V1().Services(),
+		completedConfig.ComponentConfig.KubeCloudShared.ClusterName,
+		completedConfig.ComponentConfig.ServiceLBGarbageCollector,
+	)
+	if err != nil {
+		klog.Infof("Will not garbage collect service load balancers: %v", err)
+		return nil, false, nil
+	}
+
+	go garbageCollector.Run(ctx, controlexContext.ControllerManagerMetrics)
+
+	return nil, true, nil
+}
+
 func startRouteController(ctx context.Context, initContext ControllerInitContext, controlexContext controllermanagerapp.ControllerContext, completedConfig *config.CompletedConfig, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
 	if !completedConfig.ComponentConfig.KubeCloudShared.ConfigureCloudRoutes {
 		klog.Infof("Will not configure cloud provider routes, --configure-cloud-routes: %v", completedConfig.ComponentConfig.KubeCloudShared.ConfigureCloudRoutes)
@@ -20,7 +20,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -73,6 +75,26 @@ type InformerUser interface {
 	SetInformers(informerFactory informers.SharedInformerFactory)
 }
 
+// HTTPHandlerProvider is an optional interface for cloud providers that serve additional
+// HTTP endpoints, such as cloud-side notification callbacks, on the controller manager's
+// secure port. The handlers are installed behind the secure serving authentication and
+// authorization chain.
+type HTTPHandlerProvider interface {
+	// HTTPHandlers returns the handlers to install, keyed by path.
+	HTTPHandlers() map[string]http.Handler
+}
+
+// RequeueUser is an optional interface for cloud providers that learn about cloud-side changes,
+// such as through HTTPHandlerProvider, and need the controllers to reconcile the affected
+// objects again without modifying them. The controllers register the functions adding an
+// object to their queue.
+type RequeueUser interface {
+	// AddServiceRequeueFunc registers a function requeueing a Service.
+	AddServiceRequeueFunc(requeue func(service *v1.Service))
+	// AddNodeRequeueFunc registers a function requeueing a Node.
+	AddNodeRequeueFunc(requeue func(node *v1.Node))
+}
+
 // Clusters is an abstract, pluggable interface for clusters of containers.
 type Clusters interface {
 	// ListClusters lists the names of the available clusters.
@@ -172,6 +194,38 @@ type LoadBalancer interface {
 	EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error
 }
 
+// LoadBalancerGarbageCollector is an optional interface for load balancers that can list and delete
+// the load balancers of a cluster, so load balancers left behind by deleted Services can be removed.
+// It is type-asserted on the LoadBalancer returned by Interface.LoadBalancer().
+type LoadBalancerGarbageCollector interface {
+	// ListLoadBalancers returns the load balancers created for Services of the cluster.
+	ListLoadBalancers(ctx context.Context, clusterName string) ([]LoadBalancerInfo, error)
+	// DeleteLoadBalancer deletes the load balancer with the given name, returning nil if it
+	// does not exist.
+	DeleteLoadBalancer(ctx context.Context, clusterName string, name string) error
+}
+
+// LoadBalancerSessionAffinity is an optional interface for load balancers that declare whether they
+// apply spec.sessionAffinity and spec.sessionAffinityConfig. The service controller only updates the load
+// balancer on session affinity changes if SupportsSessionAffinity returns true; load balancers that do
+// not implement the interface are updated on every change, as before.
+// It is type-asserted on the LoadBalancer returned by Interface.LoadBalancer().
+type LoadBalancerSessionAffinity interface {
+	// SupportsSessionAffinity returns true if the load balancer applies the session affinity of Services.
+	SupportsSessionAffinity() bool
+}
+
+// LoadBalancerInfo describes a load balancer returned by LoadBalancerGarbageCollector.
+type LoadBalancerInfo struct {
+	// Name is the name of the load balancer, as returned by GetLoadBalancerName.
+	Name string
+	// ServiceUID is the UID of the Service the load balancer was created for, if the
+	// cloud provider tags its load balancers with it.
+	ServiceUID types.UID
+	// CreatedAt is when the load balancer was created, zero if unknown.
+	CreatedAt time.Time
+}
+
 // Instances is an abstract, pluggable interface for sets of instances.
 type Instances interface {
 	// NodeAddresses returns the addresses of the specified instance.
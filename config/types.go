@@ -20,6 +20,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	nodeconfig "k8s.io/cloud-provider/controllers/node/config"
 	serviceconfig "k8s.io/cloud-provider/controllers/service/config"
+	servicelbgcconfig "k8s.io/cloud-provider/controllers/servicelbgc/config"
 	cmconfig "k8s.io/controller-manager/config"
 )
 
@@ -43,6 +44,10 @@ type CloudControllerManagerConfiguration struct {
 	// related features.
 	ServiceController serviceconfig.ServiceControllerConfiguration
 
+	// ServiceLBGarbageCollector holds configuration for ServiceLBGarbageCollector
+	// related features.
+	ServiceLBGarbageCollector servicelbgcconfig.ServiceLBGarbageCollectorConfiguration
+
 	// NodeStatusUpdateFrequency is the frequency at which the controller updates nodes' status
 	NodeStatusUpdateFrequency metav1.Duration
 
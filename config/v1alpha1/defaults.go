@@ -23,6 +23,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	nodeconfigv1alpha1 "k8s.io/cloud-provider/controllers/node/config/v1alpha1"
 	serviceconfigv1alpha1 "k8s.io/cloud-provider/controllers/service/config/v1alpha1"
+	servicelbgcconfigv1alpha1 "k8s.io/cloud-provider/controllers/servicelbgc/config/v1alpha1"
 	cmconfigv1alpha1 "k8s.io/controller-manager/config/v1alpha1"
 	"k8s.io/utils/ptr"
 )
@@ -52,6 +53,8 @@ func SetDefaults_CloudControllerManagerConfiguration(obj *CloudControllerManager
 	serviceconfigv1alpha1.RecommendedDefaultServiceControllerConfiguration(&obj.ServiceController)
 	// Use the default RecommendedDefaultNodeControllerConfiguration options
 	nodeconfigv1alpha1.RecommendedDefaultNodeControllerConfiguration(&obj.NodeController)
+	// Use the default RecommendedDefaultServiceLBGarbageCollectorConfiguration options
+	servicelbgcconfigv1alpha1.RecommendedDefaultServiceLBGarbageCollectorConfiguration(&obj.ServiceLBGarbageCollector)
 }
 
 func SetDefaults_KubeCloudSharedConfiguration(obj *KubeCloudSharedConfiguration) {
@@ -20,6 +20,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	nodeconfigv1alpha1 "k8s.io/cloud-provider/controllers/node/config/v1alpha1"
 	serviceconfigv1alpha1 "k8s.io/cloud-provider/controllers/service/config/v1alpha1"
+	servicelbgcconfigv1alpha1 "k8s.io/cloud-provider/controllers/servicelbgc/config/v1alpha1"
 	cmconfigv1alpha1 "k8s.io/controller-manager/config/v1alpha1"
 )
 
@@ -40,6 +41,9 @@ type CloudControllerManagerConfiguration struct {
 	// ServiceControllerConfiguration holds configuration for ServiceController
 	// related features.
 	ServiceController serviceconfigv1alpha1.ServiceControllerConfiguration
+	// ServiceLBGarbageCollector holds configuration for ServiceLBGarbageCollector
+	// related features.
+	ServiceLBGarbageCollector servicelbgcconfigv1alpha1.ServiceLBGarbageCollectorConfiguration
 	// NodeStatusUpdateFrequency is the frequency at which the controller updates nodes' status
 	NodeStatusUpdateFrequency metav1.Duration
 	// Webhook is the configuration for cloud-controller-manager hosted webhooks
@@ -30,6 +30,7 @@ import (
 	config "k8s.io/cloud-provider/config"
 	nodeconfigv1alpha1 "k8s.io/cloud-provider/controllers/node/config/v1alpha1"
 	serviceconfigv1alpha1 "k8s.io/cloud-provider/controllers/service/config/v1alpha1"
+	servicelbgcconfigv1alpha1 "k8s.io/cloud-provider/controllers/servicelbgc/config/v1alpha1"
 	configv1alpha1 "k8s.io/controller-manager/config/v1alpha1"
 )
 
@@ -96,6 +97,9 @@ func autoConvert_v1alpha1_CloudControllerManagerConfiguration_To_config_CloudCon
 	if err := serviceconfigv1alpha1.Convert_v1alpha1_ServiceControllerConfiguration_To_config_ServiceControllerConfiguration(&in.ServiceController, &out.ServiceController, s); err != nil {
 		return err
 	}
+	if err := servicelbgcconfigv1alpha1.Convert_v1alpha1_ServiceLBGarbageCollectorConfiguration_To_config_ServiceLBGarbageCollectorConfiguration(&in.ServiceLBGarbageCollector, &out.ServiceLBGarbageCollector, s); err != nil {
+		return err
+	}
 	out.NodeStatusUpdateFrequency = in.NodeStatusUpdateFrequency
 	if err := Convert_v1alpha1_WebhookConfiguration_To_config_WebhookConfiguration(&in.Webhook, &out.Webhook, s); err != nil {
 		return err
@@ -121,6 +125,9 @@ func autoConvert_config_CloudControllerManagerConfiguration_To_v1alpha1_CloudCon
 	if err := serviceconfigv1alpha1.Convert_config_ServiceControllerConfiguration_To_v1alpha1_ServiceControllerConfiguration(&in.ServiceController, &out.ServiceController, s); err != nil {
 		return err
 	}
+	if err := servicelbgcconfigv1alpha1.Convert_config_ServiceLBGarbageCollectorConfiguration_To_v1alpha1_ServiceLBGarbageCollectorConfiguration(&in.ServiceLBGarbageCollector, &out.ServiceLBGarbageCollector, s); err != nil {
+		return err
+	}
 	out.NodeStatusUpdateFrequency = in.NodeStatusUpdateFrequency
 	if err := Convert_config_WebhookConfiguration_To_v1alpha1_WebhookConfiguration(&in.Webhook, &out.Webhook, s); err != nil {
 		return err
@@ -33,6 +33,7 @@ func (in *CloudControllerManagerConfiguration) DeepCopyInto(out *CloudController
 	in.KubeCloudShared.DeepCopyInto(&out.KubeCloudShared)
 	out.NodeController = in.NodeController
 	out.ServiceController = in.ServiceController
+	out.ServiceLBGarbageCollector = in.ServiceLBGarbageCollector
 	out.NodeStatusUpdateFrequency = in.NodeStatusUpdateFrequency
 	in.Webhook.DeepCopyInto(&out.Webhook)
 	return
@@ -33,6 +33,7 @@ func (in *CloudControllerManagerConfiguration) DeepCopyInto(out *CloudController
 	out.KubeCloudShared = in.KubeCloudShared
 	out.NodeController = in.NodeController
 	out.ServiceController = in.ServiceController
+	out.ServiceLBGarbageCollector = in.ServiceLBGarbageCollector
 	out.NodeStatusUpdateFrequency = in.NodeStatusUpdateFrequency
 	in.Webhook.DeepCopyInto(&out.Webhook)
 	return
@@ -147,6 +147,13 @@ func NewCloudNodeController(
 		UpdateFunc: func(oldObj, newObj interface{}) { cnc.enqueueNode(newObj) },
 	})
 
+	// Let the cloud provider requeue nodes after cloud-side changes
+	if requeueUser, ok := cloud.(cloudprovider.RequeueUser); ok {
+		requeueUser.AddNodeRequeueFunc(func(node *v1.Node) {
+			cnc.enqueueNode(node)
+		})
+	}
+
 	return cnc, nil
 }
 
@@ -80,6 +80,8 @@ type Controller struct {
 	kubeClient  clientset.Interface
 	clusterName string
 	balancer    cloudprovider.LoadBalancer
+	// sessionAffinity is set if session affinity changes require a load balancer update
+	sessionAffinity bool
 	// TODO(#85155): Stop relying on this and remove the cache completely.
 	cache               *serviceCache
 	serviceLister       corelisters.ServiceLister
@@ -145,7 +147,7 @@ func New(
 			UpdateFunc: func(old, cur interface{}) {
 				oldSvc, ok1 := old.(*v1.Service)
 				curSvc, ok2 := cur.(*v1.Service)
-				if ok1 && ok2 && (needsUpdate(oldSvc, curSvc) || needsCleanup(curSvc)) {
+				if ok1 && ok2 && (needsUpdate(oldSvc, curSvc, s.sessionAffinity) || needsCleanup(curSvc)) {
 					s.enqueueService(cur)
 				}
 			},
@@ -186,6 +188,13 @@ func New(
 		nodeSyncPeriod,
 	)
 
+	// Let the cloud provider requeue services after cloud-side changes
+	if requeueUser, ok := cloud.(cloudprovider.RequeueUser); ok {
+		requeueUser.AddServiceRequeueFunc(func(service *v1.Service) {
+			s.enqueueService(service)
+		})
+	}
+
 	return s, nil
 }
 
@@ -295,9 +304,14 @@ func (c *Controller) processNextServiceItem(ctx context.Context) bool {
 	}
 
 	var re *api.RetryError
+	var nre *api.NonRetryableError
 	if errors.As(err, &re) {
 		klog.Warningf("error processing service %v (retrying in %s): %v", key, re.RetryAfter(), err)
 		c.serviceQueue.AddAfter(key, re.RetryAfter())
+	} else if errors.As(err, &nre) {
+		// The service is synced again when it changes
+		runtime.HandleError(fmt.Errorf("error processing service %v (not retrying until the service changes): %v", key, err))
+		c.serviceQueue.Forget(key)
 	} else {
 		runtime.HandleError(fmt.Errorf("error processing service %v (retrying with exponential backoff): %v", key, err))
 		c.serviceQueue.AddRateLimited(key)
@@ -317,6 +331,11 @@ func (c *Controller) init() error {
 	}
 	c.balancer = balancer
 
+	c.sessionAffinity = true
+	if affinity, ok := balancer.(cloudprovider.LoadBalancerSessionAffinity); ok {
+		c.sessionAffinity = affinity.SupportsSessionAffinity()
+	}
+
 	return nil
 }
 
@@ -554,7 +573,8 @@ func needsCleanup(service *v1.Service) bool {
 }
 
 // needsUpdate checks if load balancer needs to be updated due to change in attributes.
-func needsUpdate(oldService *v1.Service, newService *v1.Service) bool {
+// Session affinity changes are only considered if sessionAffinity is set.
+func needsUpdate(oldService *v1.Service, newService *v1.Service, sessionAffinity bool) bool {
 	if !wantsLoadBalancer(oldService) && !wantsLoadBalancer(newService) {
 		return false
 	}
@@ -568,11 +588,13 @@ func needsUpdate(oldService *v1.Service, newService *v1.Service) bool {
 		return true
 	}
 
-	if !portsEqualForLB(oldService, newService) || oldService.Spec.SessionAffinity != newService.Spec.SessionAffinity {
+	if !portsEqualForLB(oldService, newService) {
 		return true
 	}
 
-	if !reflect.DeepEqual(oldService.Spec.SessionAffinityConfig, newService.Spec.SessionAffinityConfig) {
+	if sessionAffinity && (oldService.Spec.SessionAffinity != newService.Spec.SessionAffinity ||
+		!reflect.DeepEqual(oldService.Spec.SessionAffinityConfig, newService.Spec.SessionAffinityConfig)) {
+		klog.V(2).Infof("Service %s SessionAffinity changed from %s to %s", klog.KObj(newService), oldService.Spec.SessionAffinity, newService.Spec.SessionAffinity)
 		return true
 	}
 	if !loadBalancerIPsAreEqual(oldService, newService) {
@@ -1340,6 +1340,7 @@ func TestNeedsUpdate(t *testing.T) {
 		testName            string                            //Name of the test case
 		updateFn            func() (*v1.Service, *v1.Service) //Function to update the service object
 		expectedNeedsUpdate bool                              //needsupdate always returns bool
+		noSessionAffinity   bool                              //the load balancer does not support session affinity
 
 	}{{
 		testName: "If the service type is changed from LoadBalancer to ClusterIP",
@@ -1453,6 +1454,35 @@ func TestNeedsUpdate(t *testing.T) {
 			return
 		},
 		expectedNeedsUpdate: false,
+	}, {
+		testName: "If session affinity is changed",
+		updateFn: func() (oldSvc *v1.Service, newSvc *v1.Service) {
+			oldSvc = defaultExternalService()
+			newSvc = defaultExternalService()
+			newSvc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+			return
+		},
+		expectedNeedsUpdate: true,
+	}, {
+		testName: "If session affinity timeout is changed",
+		updateFn: func() (oldSvc *v1.Service, newSvc *v1.Service) {
+			oldSvc = defaultExternalService()
+			oldSvc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+			newSvc = oldSvc.DeepCopy()
+			newSvc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: ptr.To[int32](600)}}
+			return
+		},
+		expectedNeedsUpdate: true,
+	}, {
+		testName: "If session affinity is changed without load balancer support",
+		updateFn: func() (oldSvc *v1.Service, newSvc *v1.Service) {
+			oldSvc = defaultExternalService()
+			newSvc = defaultExternalService()
+			newSvc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+			return
+		},
+		expectedNeedsUpdate: false,
+		noSessionAffinity:   true,
 	}, {
 		testName: "If service IPFamilies from single stack to dual stack",
 		updateFn: func() (oldSvc *v1.Service, newSvc *v1.Service) {
@@ -1498,7 +1528,7 @@ func TestNeedsUpdate(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
 			oldSvc, newSvc := tc.updateFn()
-			obtainedResult := needsUpdate(oldSvc, newSvc)
+			obtainedResult := needsUpdate(oldSvc, newSvc, !tc.noSessionAffinity)
 			if obtainedResult != tc.expectedNeedsUpdate {
 				t.Errorf("%v needsUpdate() should have returned %v but returned %v", tc.testName, tc.expectedNeedsUpdate, obtainedResult)
 			}
@@ -2310,6 +2340,10 @@ func TestServiceQueueDelay(t *testing.T) {
 			lbCloudErr:     api.NewRetryError("LB create in progress", 42*time.Second),
 			wantRetryDelay: 42 * time.Second,
 		},
+		{
+			name:       "non-retryable error",
+			lbCloudErr: api.NewNonRetryableError("unsupported protocol"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -2353,10 +2387,17 @@ func TestServiceQueueDelay(t *testing.T) {
 			// error.
 			wantNumRequeues := 0
 			var re *api.RetryError
+			var nre *api.NonRetryableError
 			isRetryError := errors.As(tc.lbCloudErr, &re)
-			if tc.lbCloudErr != nil && !isRetryError {
+			isNonRetryableError := errors.As(tc.lbCloudErr, &nre)
+			if tc.lbCloudErr != nil && !isRetryError && !isNonRetryableError {
 				wantNumRequeues = 1
 			}
+			if isNonRetryableError {
+				if items := queue.getItems(); len(items) != 0 {
+					t.Fatalf("got %d item(s) requeued after a non-retryable error, want 0", len(items))
+				}
+			}
 
 			if gotNumRequeues := queue.NumRequeues(key); gotNumRequeues != wantNumRequeues {
 				t.Fatalf("got %d requeue(s), want %d", gotNumRequeues, wantNumRequeues)
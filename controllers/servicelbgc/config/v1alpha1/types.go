@@ -0,0 +1,32 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceLBGarbageCollectorConfiguration contains elements describing ServiceLBGarbageCollector.
+type ServiceLBGarbageCollectorConfiguration struct {
+	// GarbageCollectionPeriod is the period for listing the load balancers of the cluster
+	// and deleting the ones whose Service no longer exists.
+	GarbageCollectionPeriod metav1.Duration
+	// OrphanGracePeriod is how long a load balancer must be orphaned before it is deleted.
+	OrphanGracePeriod metav1.Duration
+	// DryRun only logs and counts orphaned load balancers instead of deleting them.
+	DryRun bool
+}
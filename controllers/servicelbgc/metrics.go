@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package servicelbgc
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// subsystem is the name of this subsystem used for prometheus metrics.
+	subsystem = "service_lb_garbage_collector"
+)
+
+var registration sync.Once
+
+var (
+	garbageCollectionCount = metrics.NewCounterVec(&metrics.CounterOpts{
+		Name:           "runs_total",
+		Subsystem:      subsystem,
+		Help:           "A metric counting the garbage collection runs, by result (success or error).",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+	orphanedLoadBalancers = metrics.NewGauge(&metrics.GaugeOpts{
+		Name:           "orphaned_load_balancers",
+		Subsystem:      subsystem,
+		Help:           "A metric reporting the number of cloud load balancers without a Service found by the last garbage collection run.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	deletedLoadBalancerCount = metrics.NewCounterVec(&metrics.CounterOpts{
+		Name:           "deleted_load_balancers_total",
+		Subsystem:      subsystem,
+		Help:           "A metric counting the orphaned cloud load balancers deleted, by result (success, error or dry_run).",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+)
+
+func registerMetrics() {
+	registration.Do(func() {
+		legacyregistry.MustRegister(garbageCollectionCount)
+		legacyregistry.MustRegister(orphanedLoadBalancers)
+		legacyregistry.MustRegister(deletedLoadBalancerCount)
+	})
+}
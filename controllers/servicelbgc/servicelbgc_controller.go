@@ -0,0 +1,195 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package servicelbgc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	servicelbgcconfig "k8s.io/cloud-provider/controllers/servicelbgc/config"
+	"k8s.io/cloud-provider/names"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+// Controller periodically lists the cloud load balancers of the cluster and deletes the ones
+// whose Service no longer exists, for example because the Service was deleted while the
+// controller manager was down or its finalizer was removed by hand.
+type Controller struct {
+	balancer            cloudprovider.LoadBalancer
+	collector           cloudprovider.LoadBalancerGarbageCollector
+	clusterName         string
+	period              time.Duration
+	gracePeriod         time.Duration
+	dryRun              bool
+	serviceLister       corelisters.ServiceLister
+	serviceListerSynced cache.InformerSynced
+
+	// orphanedSince holds when each orphaned load balancer was first seen without a Service
+	orphanedSince map[string]time.Time
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// New returns a new service load balancer garbage collector.
+func New(
+	cloud cloudprovider.Interface,
+	serviceInformer coreinformers.ServiceInformer,
+	clusterName string,
+	cfg servicelbgcconfig.ServiceLBGarbageCollectorConfiguration,
+) (*Controller, error) {
+	registerMetrics()
+
+	balancer, ok := cloud.LoadBalancer()
+	if !ok {
+		return nil, errors.New("the cloud provider does not support external load balancers")
+	}
+	collector, ok := balancer.(cloudprovider.LoadBalancerGarbageCollector)
+	if !ok {
+		return nil, errors.New("the cloud provider does not support listing load balancers")
+	}
+	if cfg.GarbageCollectionPeriod.Duration <= 0 {
+		return nil, fmt.Errorf("garbage collection period must be positive, got %v", cfg.GarbageCollectionPeriod.Duration)
+	}
+
+	return &Controller{
+		balancer:            balancer,
+		collector:           collector,
+		clusterName:         clusterName,
+		period:              cfg.GarbageCollectionPeriod.Duration,
+		gracePeriod:         cfg.OrphanGracePeriod.Duration,
+		dryRun:              cfg.DryRun,
+		serviceLister:       serviceInformer.Lister(),
+		serviceListerSynced: serviceInformer.Informer().HasSynced,
+		orphanedSince:       make(map[string]time.Time),
+		now:                 time.Now,
+	}, nil
+}
+
+// Run starts the garbage collection loop, which runs until the context is done.
+func (c *Controller) Run(ctx context.Context, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	klog.Info("Starting service load balancer garbage collector")
+	defer klog.Info("Shutting down service load balancer garbage collector")
+	controllerManagerMetrics.ControllerStarted(names.ServiceLBGarbageCollectorController)
+	defer controllerManagerMetrics.ControllerStopped(names.ServiceLBGarbageCollectorController)
+
+	if !cache.WaitForNamedCacheSyncWithContext(ctx, c.serviceListerSynced) {
+		return
+	}
+
+	if c.dryRun {
+		klog.Info("Service load balancer garbage collector runs in dry-run mode, orphaned load balancers are not deleted")
+	}
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.collect(ctx); err != nil {
+			garbageCollectionCount.WithLabelValues("error").Inc()
+			utilruntime.HandleError(fmt.Errorf("service load balancer garbage collection failed: %w", err))
+			return
+		}
+		garbageCollectionCount.WithLabelValues("success").Inc()
+	}, c.period)
+}
+
+// collect deletes the load balancers that have been without a Service for the grace period.
+func (c *Controller) collect(ctx context.Context) error {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %w", err)
+	}
+
+	ownedNames := sets.New[string]()
+	ownedUIDs := sets.New[types.UID]()
+	for _, service := range services {
+		if !wantsLoadBalancer(service) {
+			continue
+		}
+		ownedNames.Insert(c.balancer.GetLoadBalancerName(ctx, c.clusterName, service))
+		ownedUIDs.Insert(service.UID)
+	}
+
+	loadBalancers, err := c.collector.ListLoadBalancers(ctx, c.clusterName)
+	if err != nil {
+		return fmt.Errorf("failed to list load balancers: %w", err)
+	}
+
+	now := c.now()
+	orphaned := make(map[string]time.Time)
+	var errs []error
+	for _, lb := range loadBalancers {
+		if ownedNames.Has(lb.Name) || (lb.ServiceUID != "" && ownedUIDs.Has(lb.ServiceUID)) {
+			continue
+		}
+
+		since, ok := c.orphanedSince[lb.Name]
+		if !ok {
+			since = now
+			klog.V(2).Infof("Load balancer %s has no Service, deleting it after %v", lb.Name, c.gracePeriod)
+		}
+		// A load balancer created shortly before its Service appeared in the cache is not orphaned
+		if !lb.CreatedAt.IsZero() && lb.CreatedAt.After(since) {
+			since = lb.CreatedAt
+		}
+		orphaned[lb.Name] = since
+
+		if now.Sub(since) < c.gracePeriod {
+			continue
+		}
+
+		if c.dryRun {
+			klog.Infof("Dry run: would delete load balancer %s, without a Service since %v", lb.Name, since.Format(time.RFC3339))
+			deletedLoadBalancerCount.WithLabelValues("dry_run").Inc()
+			continue
+		}
+
+		klog.Infof("Deleting load balancer %s, without a Service since %v", lb.Name, since.Format(time.RFC3339))
+		if err := c.collector.DeleteLoadBalancer(ctx, c.clusterName, lb.Name); err != nil {
+			deletedLoadBalancerCount.WithLabelValues("error").Inc()
+			errs = append(errs, fmt.Errorf("failed to delete load balancer %s: %w", lb.Name, err))
+			continue
+		}
+		deletedLoadBalancerCount.WithLabelValues("success").Inc()
+		delete(orphaned, lb.Name)
+	}
+
+	// Load balancers that regained a Service or were deleted are forgotten
+	c.orphanedSince = orphaned
+	orphanedLoadBalancers.Set(float64(len(orphaned)))
+
+	return errors.Join(errs...)
+}
+
+// wantsLoadBalancer checks if the service owns a load balancer: it is of type LoadBalancer,
+// or the service controller has not finished deleting its load balancer yet
+func wantsLoadBalancer(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer || servicehelpers.HasLBFinalizer(service)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package servicelbgc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+	servicelbgcconfig "k8s.io/cloud-provider/controllers/servicelbgc/config"
+	servicelbgcconfigv1alpha1 "k8s.io/cloud-provider/controllers/servicelbgc/config/v1alpha1"
+	fakecloud "k8s.io/cloud-provider/fake"
+)
+
+// fakeLoadBalancer is a load balancer that can be garbage collected
+type fakeLoadBalancer struct {
+	*fakecloud.Cloud
+	loadBalancers []cloudprovider.LoadBalancerInfo
+	deleted       []string
+}
+
+func (f *fakeLoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return fmt.Sprintf("%s-%s-%s", clusterName, service.Namespace, service.Name)
+}
+
+func (f *fakeLoadBalancer) ListLoadBalancers(ctx context.Context, clusterName string) ([]cloudprovider.LoadBalancerInfo, error) {
+	return f.loadBalancers, nil
+}
+
+func (f *fakeLoadBalancer) DeleteLoadBalancer(ctx context.Context, clusterName string, name string) error {
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+// fakeCloud returns the garbage collectable load balancer
+type fakeCloud struct {
+	*fakecloud.Cloud
+	lb *fakeLoadBalancer
+}
+
+func (f *fakeCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return f.lb, true
+}
+
+func newService(name string, uid types.UID, serviceType v1.ServiceType) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: uid},
+		Spec:       v1.ServiceSpec{Type: serviceType},
+	}
+}
+
+func TestNewRequiresGarbageCollector(t *testing.T) {
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	cfg := servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{GarbageCollectionPeriod: metav1.Duration{Duration: time.Minute}}
+
+	if _, err := New(&fakecloud.Cloud{}, informerFactory.Core().V1().Services(), "kubernetes", cfg); err == nil {
+		t.Error("expected error for a cloud without load balancer garbage collection")
+	}
+}
+
+func TestCollect(t *testing.T) {
+	gracePeriod := time.Hour
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc          string
+		services      []*v1.Service
+		loadBalancers []cloudprovider.LoadBalancerInfo
+		dryRun        bool
+		expectDeleted []string
+		expectOrphans int
+	}{
+		{
+			desc:     "load balancers of existing services are kept",
+			services: []*v1.Service{newService("web", "uid-1", v1.ServiceTypeLoadBalancer)},
+			loadBalancers: []cloudprovider.LoadBalancerInfo{
+				{Name: "kubernetes-default-web"},
+				{Name: "renamed", ServiceUID: "uid-1"},
+			},
+		},
+		{
+			desc:          "orphaned load balancers are deleted after the grace period",
+			services:      []*v1.Service{newService("web", "uid-1", v1.ServiceTypeClusterIP)},
+			loadBalancers: []cloudprovider.LoadBalancerInfo{{Name: "kubernetes-default-web"}, {Name: "kubernetes-default-gone", ServiceUID: "uid-2"}},
+			expectDeleted: []string{"kubernetes-default-web", "kubernetes-default-gone"},
+		},
+		{
+			desc:          "recently created load balancers are kept",
+			loadBalancers: []cloudprovider.LoadBalancerInfo{{Name: "kubernetes-default-new", CreatedAt: start.Add(30 * time.Minute)}},
+			expectOrphans: 1,
+		},
+		{
+			desc:          "dry run deletes nothing",
+			loadBalancers: []cloudprovider.LoadBalancerInfo{{Name: "kubernetes-default-gone"}},
+			dryRun:        true,
+			expectOrphans: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+			serviceInformer := informerFactory.Core().V1().Services()
+			for _, service := range tc.services {
+				if err := serviceInformer.Informer().GetStore().Add(service); err != nil {
+					t.Fatalf("failed to add service: %v", err)
+				}
+			}
+
+			lb := &fakeLoadBalancer{loadBalancers: tc.loadBalancers}
+			cloud := &fakeCloud{Cloud: &fakecloud.Cloud{}, lb: lb}
+			cfg := servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{
+				GarbageCollectionPeriod: metav1.Duration{Duration: time.Minute},
+				OrphanGracePeriod:       metav1.Duration{Duration: gracePeriod},
+				DryRun:                  tc.dryRun,
+			}
+			controller, err := New(cloud, serviceInformer, "kubernetes", cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The first run only records the orphans
+			now := start
+			controller.now = func() time.Time { return now }
+			if err := controller.collect(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(lb.deleted) != 0 {
+				t.Fatalf("expected no deletion within the grace period, got %v", lb.deleted)
+			}
+
+			now = start.Add(gracePeriod)
+			if err := controller.collect(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectDeleted, lb.deleted); diff != "" {
+				t.Errorf("unexpected deletions (-want +got):\n%s", diff)
+			}
+			if len(controller.orphanedSince) != tc.expectOrphans {
+				t.Errorf("expected %d tracked orphans, got %v", tc.expectOrphans, controller.orphanedSince)
+			}
+		})
+	}
+}
+
+func TestCollectDefaultConfiguration(t *testing.T) {
+	var versioned servicelbgcconfigv1alpha1.ServiceLBGarbageCollectorConfiguration
+	servicelbgcconfigv1alpha1.RecommendedDefaultServiceLBGarbageCollectorConfiguration(&versioned)
+	var cfg servicelbgcconfig.ServiceLBGarbageCollectorConfiguration
+	if err := servicelbgcconfigv1alpha1.Convert_v1alpha1_ServiceLBGarbageCollectorConfiguration_To_config_ServiceLBGarbageCollectorConfiguration(&versioned, &cfg, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	lb := &fakeLoadBalancer{loadBalancers: []cloudprovider.LoadBalancerInfo{{Name: "kubernetes-default-gone"}}}
+	cloud := &fakeCloud{Cloud: &fakecloud.Cloud{}, lb: lb}
+	controller, err := New(cloud, informerFactory.Core().V1().Services(), "kubernetes", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Orphans are kept for an hour by default
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, elapsed := range []time.Duration{0, 59 * time.Minute} {
+		controller.now = func() time.Time { return start.Add(elapsed) }
+		if err := controller.collect(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(lb.deleted) != 0 {
+			t.Fatalf("expected no deletion after %v, got %v", elapsed, lb.deleted)
+		}
+	}
+
+	controller.now = func() time.Time { return start.Add(time.Hour) }
+	if err := controller.collect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"kubernetes-default-gone"}, lb.deleted); diff != "" {
+		t.Errorf("unexpected deletions (-want +got):\n%s", diff)
+	}
+}
@@ -47,10 +47,11 @@ package names
 //  4. defining a new service account for a new controller (old controllers may have inconsistent service accounts to stay backwards compatible)
 //  5. anywhere these controllers are used outside of this module (kube-controller-manager, cloud-provider sample)
 const (
-	CloudNodeController          = "cloud-node-controller"
-	ServiceLBController          = "service-lb-controller"
-	NodeRouteController          = "node-route-controller"
-	CloudNodeLifecycleController = "cloud-node-lifecycle-controller"
+	CloudNodeController                 = "cloud-node-controller"
+	ServiceLBController                 = "service-lb-controller"
+	NodeRouteController                 = "node-route-controller"
+	CloudNodeLifecycleController        = "cloud-node-lifecycle-controller"
+	ServiceLBGarbageCollectorController = "service-lb-garbage-collector-controller"
 )
 
 // CCMControllerAliases returns a mapping of aliases to canonical controller names
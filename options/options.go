@@ -61,6 +61,8 @@ type CloudControllerManagerOptions struct {
 	ServiceController *ServiceControllerOptions
 	NodeController    *NodeControllerOptions
 
+	ServiceLBGarbageCollector *ServiceLBGarbageCollectorOptions
+
 	SecureServing  *apiserveroptions.SecureServingOptionsWithLoopback
 	Authentication *apiserveroptions.DelegatingAuthenticationOptions
 	Authorization  *apiserveroptions.DelegatingAuthorizationOptions
@@ -107,6 +109,9 @@ func NewCloudControllerManagerOptionsWithProviderDefaults(defaults ProviderDefau
 		ServiceController: &ServiceControllerOptions{
 			ServiceControllerConfiguration: &componentConfig.ServiceController,
 		},
+		ServiceLBGarbageCollector: &ServiceLBGarbageCollectorOptions{
+			ServiceLBGarbageCollectorConfiguration: &componentConfig.ServiceLBGarbageCollector,
+		},
 		SecureServing:             apiserveroptions.NewSecureServingOptions().WithLoopback(),
 		Webhook:                   NewWebhookOptions(),
 		WebhookServing:            NewWebhookServingOptions(defaults),
@@ -149,6 +154,7 @@ func (o *CloudControllerManagerOptions) Flags(allControllers []string, disabledB
 	o.KubeCloudShared.AddFlags(fss.FlagSet("generic"))
 	o.NodeController.AddFlags(fss.FlagSet(names.CloudNodeController))
 	o.ServiceController.AddFlags(fss.FlagSet(names.ServiceLBController))
+	o.ServiceLBGarbageCollector.AddFlags(fss.FlagSet(names.ServiceLBGarbageCollectorController))
 	if o.Webhook != nil {
 		o.Webhook.AddFlags(fss.FlagSet("webhook"), allWebhooks, disabledByDefaultWebhooks)
 	}
@@ -195,6 +201,9 @@ func (o *CloudControllerManagerOptions) ApplyTo(c *config.Config, allControllers
 	if err = o.ServiceController.ApplyTo(&c.ComponentConfig.ServiceController); err != nil {
 		return err
 	}
+	if err = o.ServiceLBGarbageCollector.ApplyTo(&c.ComponentConfig.ServiceLBGarbageCollector); err != nil {
+		return err
+	}
 	if o.Webhook != nil {
 		if err = o.Webhook.ApplyTo(&c.ComponentConfig.Webhook); err != nil {
 			return err
@@ -254,6 +263,7 @@ func (o *CloudControllerManagerOptions) Validate(allControllers []string, disabl
 	errors = append(errors, o.Generic.Validate(allControllers, disabledByDefaultControllers, controllerAliases)...)
 	errors = append(errors, o.KubeCloudShared.Validate()...)
 	errors = append(errors, o.ServiceController.Validate()...)
+	errors = append(errors, o.ServiceLBGarbageCollector.Validate()...)
 	errors = append(errors, o.SecureServing.Validate()...)
 	errors = append(errors, o.Authentication.Validate()...)
 	errors = append(errors, o.Authorization.Validate()...)
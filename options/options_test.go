@@ -33,6 +33,7 @@ import (
 	cpconfig "k8s.io/cloud-provider/config"
 	nodeconfig "k8s.io/cloud-provider/controllers/node/config"
 	serviceconfig "k8s.io/cloud-provider/controllers/service/config"
+	servicelbgcconfig "k8s.io/cloud-provider/controllers/servicelbgc/config"
 	componentbaseconfig "k8s.io/component-base/config"
 	cmconfig "k8s.io/controller-manager/config"
 	cmoptions "k8s.io/controller-manager/options"
@@ -107,6 +108,12 @@ func TestDefaultFlags(t *testing.T) {
 				ConcurrentServiceSyncs: 1,
 			},
 		},
+		ServiceLBGarbageCollector: &ServiceLBGarbageCollectorOptions{
+			ServiceLBGarbageCollectorConfiguration: &servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{
+				GarbageCollectionPeriod: metav1.Duration{Duration: 10 * time.Minute},
+				OrphanGracePeriod:       metav1.Duration{Duration: time.Hour},
+			},
+		},
 		Webhook: &WebhookOptions{},
 		WebhookServing: &WebhookServingOptions{
 			SecureServingOptions: &apiserveroptions.SecureServingOptions{
@@ -201,6 +208,9 @@ func TestAddFlags(t *testing.T) {
 		"--secure-port=10001",
 		"--use-service-account-credentials=false",
 		"--concurrent-node-syncs=5",
+		"--service-lb-garbage-collection-dry-run=true",
+		"--service-lb-garbage-collection-period=30m",
+		"--service-lb-orphan-grace-period=2h",
 		"--webhooks=foo,bar,-baz",
 	}
 	err = fs.Parse(args)
@@ -266,6 +276,13 @@ func TestAddFlags(t *testing.T) {
 				ConcurrentServiceSyncs: 1,
 			},
 		},
+		ServiceLBGarbageCollector: &ServiceLBGarbageCollectorOptions{
+			ServiceLBGarbageCollectorConfiguration: &servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{
+				GarbageCollectionPeriod: metav1.Duration{Duration: 30 * time.Minute},
+				OrphanGracePeriod:       metav1.Duration{Duration: 2 * time.Hour},
+				DryRun:                  true,
+			},
+		},
 		Webhook: &WebhookOptions{
 			Webhooks: []string{"foo", "bar", "-baz"},
 		},
@@ -423,6 +440,10 @@ func TestCreateConfig(t *testing.T) {
 			ServiceController: serviceconfig.ServiceControllerConfiguration{
 				ConcurrentServiceSyncs: 1,
 			},
+			ServiceLBGarbageCollector: servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{
+				GarbageCollectionPeriod: metav1.Duration{Duration: 10 * time.Minute},
+				OrphanGracePeriod:       metav1.Duration{Duration: time.Hour},
+			},
 			NodeController:            nodeconfig.NodeControllerConfiguration{ConcurrentNodeSyncs: 1},
 			NodeStatusUpdateFrequency: metav1.Duration{Duration: 10 * time.Minute},
 			Webhook: cpconfig.WebhookConfiguration{
@@ -564,6 +585,10 @@ func TestCreateConfigWithoutWebHooks(t *testing.T) {
 			ServiceController: serviceconfig.ServiceControllerConfiguration{
 				ConcurrentServiceSyncs: 1,
 			},
+			ServiceLBGarbageCollector: servicelbgcconfig.ServiceLBGarbageCollectorConfiguration{
+				GarbageCollectionPeriod: metav1.Duration{Duration: 10 * time.Minute},
+				OrphanGracePeriod:       metav1.Duration{Duration: time.Hour},
+			},
 			NodeController:            nodeconfig.NodeControllerConfiguration{ConcurrentNodeSyncs: 1},
 			NodeStatusUpdateFrequency: metav1.Duration{Duration: 10 * time.Minute},
 			Webhook:                   cpconfig.WebhookConfiguration{},
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	servicelbgcconfig "k8s.io/cloud-provider/controllers/servicelbgc/config"
+)
+
+// ServiceLBGarbageCollectorOptions holds the ServiceLBGarbageCollector options.
+type ServiceLBGarbageCollectorOptions struct {
+	*servicelbgcconfig.ServiceLBGarbageCollectorConfiguration
+}
+
+// AddFlags adds flags related to ServiceLBGarbageCollector for controller manager to the specified FlagSet.
+func (o *ServiceLBGarbageCollectorOptions) AddFlags(fs *pflag.FlagSet) {
+	if o == nil {
+		return
+	}
+
+	fs.DurationVar(&o.GarbageCollectionPeriod.Duration, "service-lb-garbage-collection-period", o.GarbageCollectionPeriod.Duration, "The period for listing the cloud load balancers of the cluster and deleting the ones whose Service no longer exists.")
+	fs.DurationVar(&o.OrphanGracePeriod.Duration, "service-lb-orphan-grace-period", o.OrphanGracePeriod.Duration, "How long a cloud load balancer must be without a Service before it is deleted.")
+	fs.BoolVar(&o.DryRun, "service-lb-garbage-collection-dry-run", o.DryRun, "Only log and count orphaned cloud load balancers instead of deleting them.")
+}
+
+// ApplyTo fills up ServiceLBGarbageCollector config with options.
+func (o *ServiceLBGarbageCollectorOptions) ApplyTo(cfg *servicelbgcconfig.ServiceLBGarbageCollectorConfiguration) error {
+	if o == nil {
+		return nil
+	}
+
+	cfg.GarbageCollectionPeriod = o.GarbageCollectionPeriod
+	cfg.OrphanGracePeriod = o.OrphanGracePeriod
+	cfg.DryRun = o.DryRun
+
+	return nil
+}
+
+// Validate checks validation of ServiceLBGarbageCollectorOptions.
+func (o *ServiceLBGarbageCollectorOptions) Validate() []error {
+	if o == nil {
+		return nil
+	}
+
+	var errors []error
+	if o.GarbageCollectionPeriod.Duration <= 0 {
+		errors = append(errors, fmt.Errorf("service-lb-garbage-collection-period must be a positive duration"))
+	}
+	if o.OrphanGracePeriod.Duration < 0 {
+		errors = append(errors, fmt.Errorf("service-lb-orphan-grace-period must not be negative"))
+	}
+	return errors
+}
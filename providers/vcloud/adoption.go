@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// checkAdoption makes sure the ingress named by the adopt annotation exists, adopted ingresses are never
+// created, and records an event when an untagged ingress is taken over
+func (lb *VCloudLoadBalancer) checkAdoption(service *v1.Service, name string, current *LoadBalancerResponse) error {
+	if adoptedIngressName(service) != name {
+		return nil
+	}
+
+	if current == nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonAdoptedLoadBalancerNotFound,
+			"Load balancer %s named by %s does not exist", name, ServiceAnnotationLoadBalancerAdopt)
+		return fmt.Errorf("load balancer %s to adopt for service %s/%s does not exist", name, service.Namespace, service.Name)
+	}
+
+	if ingressTags(current)[TagServiceUID] == "" {
+		klog.V(2).Infof("Adopting load balancer %s for service %s/%s", name, service.Namespace, service.Name)
+		lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerAdopted, "Adopting existing load balancer %s", name)
+	}
+	return nil
+}
+
+// checkAdoptedName refuses to adopt an ingress named like the load balancers of the cluster. Those belong
+// to other services, possibly of other namespaces, even before they are tagged, as under the legacy naming
+// scheme.
+func (lb *VCloudLoadBalancer) checkAdoptedName(clusterName string, service *v1.Service) error {
+	name := adoptedIngressName(service)
+	if name == "" {
+		return nil
+	}
+
+	prefixes := []string{
+		loadBalancerNamePrefix(clusterName),
+		loadBalancerNamePrefix(lb.provider.clusterName),
+		clusterName + "-ingress-",
+		lb.provider.clusterName + "-ingress-",
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidAnnotation,
+				"Invalid load balancer annotations: %s cannot adopt %s, names starting with %s belong to the load balancers of the cluster",
+				ServiceAnnotationLoadBalancerAdopt, name, prefix)
+			return fmt.Errorf("invalid annotations on service %s/%s: %s cannot adopt load balancer %s of the cluster",
+				service.Namespace, service.Name, ServiceAnnotationLoadBalancerAdopt, name)
+		}
+	}
+	return nil
+}
+
+// releaseIngress hands the ingress back instead of deleting it: its backends and ownership tags are
+// removed, its frontends and public IPs are kept
+func (lb *VCloudLoadBalancer) releaseIngress(ctx context.Context, service *v1.Service, name string) error {
+	klog.V(2).Infof("Releasing load balancer %s", name)
+	lb.clearProvisioning(name)
+
+	// The service tags are removed and the ingress marked as retained, the cluster ID tag keeps other
+	// clusters away from it
+	patch := map[string]interface{}{
+		"nodes":    []string{},
+		"backends": nil,
+		"tags": map[string]interface{}{
+			TagClusterID:        lb.provider.clusterID,
+			TagRetained:         "true",
+			TagServiceNamespace: nil,
+			TagServiceName:      nil,
+			TagServiceUID:       nil,
+			TagSharingKey:       nil,
+		},
+	}
+	path := fmt.Sprintf("/ingresses/%s", name)
+	released, _, err := lb.ingressRequest(ctx, service, "PATCH", path, "Releasing load balancer "+name, patch)
+	if err != nil {
+		return err
+	}
+	if released == nil {
+		klog.V(4).Infof("Load balancer %s already deleted", name)
+		return nil
+	}
+
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerReleased,
+		"Released load balancer %s, it is kept with its public IPs", name)
+	klog.V(2).Infof("Successfully released load balancer %s", name)
+	return nil
+}
@@ -0,0 +1,442 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// annotationPrefix is the prefix of all vcloud load balancer Service annotations
+const annotationPrefix = "k8s.io.infra.vnetwork.dev/load-balancer-"
+
+const (
+	// ServiceAnnotationLoadBalancerInternal creates the load balancer on the internal network when "true"
+	ServiceAnnotationLoadBalancerInternal = annotationPrefix + "internal"
+	// ServiceAnnotationLoadBalancerNetwork selects the frontend network, overriding the cloud config default
+	ServiceAnnotationLoadBalancerNetwork = annotationPrefix + "network"
+	// ServiceAnnotationLoadBalancerSubnet selects the frontend subnet, overriding the cloud config default
+	ServiceAnnotationLoadBalancerSubnet = annotationPrefix + "subnet"
+	// ServiceAnnotationLoadBalancerAlgorithm selects the balancing algorithm
+	ServiceAnnotationLoadBalancerAlgorithm = annotationPrefix + "algorithm"
+	// ServiceAnnotationLoadBalancerIdleTimeout sets the idle connection timeout in seconds
+	ServiceAnnotationLoadBalancerIdleTimeout = annotationPrefix + "idle-timeout"
+	// ServiceAnnotationLoadBalancerConnectionDrainingTimeout sets how long in seconds connections
+	// to a removed backend are drained, 0 disables draining
+	ServiceAnnotationLoadBalancerConnectionDrainingTimeout = annotationPrefix + "connection-draining-timeout"
+	// ServiceAnnotationLoadBalancerProxyProtocol enables the PROXY protocol towards the backends when "true"
+	ServiceAnnotationLoadBalancerProxyProtocol = annotationPrefix + "proxy-protocol"
+	// ServiceAnnotationLoadBalancerIP requests a specific public IP from the tenant pool
+	ServiceAnnotationLoadBalancerIP = annotationPrefix + "ip"
+	// ServiceAnnotationLoadBalancerReservedIP binds the reserved floating IP with the given name
+	ServiceAnnotationLoadBalancerReservedIP = annotationPrefix + "reserved-ip"
+	// ServiceAnnotationLoadBalancerAdopt takes over the existing ingress with the given name
+	ServiceAnnotationLoadBalancerAdopt = annotationPrefix + "adopt"
+	// ServiceAnnotationLoadBalancerSharingKey shares one ingress between the Services of a namespace with the same key
+	ServiceAnnotationLoadBalancerSharingKey = annotationPrefix + "sharing-key"
+	// ServiceAnnotationLoadBalancerRetainPolicy selects what happens to the ingress when the Service is deleted
+	ServiceAnnotationLoadBalancerRetainPolicy = annotationPrefix + "retain-policy"
+	// ServiceAnnotationLoadBalancerDeletionProtection blocks the deletion of the load balancer when "true"
+	ServiceAnnotationLoadBalancerDeletionProtection = annotationPrefix + "deletion-protection"
+	// ServiceAnnotationLoadBalancerNodeSelector restricts the backends to the nodes matching the label selector
+	ServiceAnnotationLoadBalancerNodeSelector = annotationPrefix + "node-selector"
+	// ServiceAnnotationLoadBalancerZonePolicy balances traffic between the zones of the backends
+	ServiceAnnotationLoadBalancerZonePolicy = annotationPrefix + "zone-policy"
+	// ServiceAnnotationLoadBalancerTLSCertificates lists the kubernetes.io/tls Secrets of the namespace and the
+	// vcloud certificate IDs, prefixed with "vcloud:", served on the TLS ports
+	ServiceAnnotationLoadBalancerTLSCertificates = annotationPrefix + "tls-certificates"
+	// ServiceAnnotationLoadBalancerTLSPorts lists the names or numbers of the ports terminating TLS, all TCP
+	// ports if unset
+	ServiceAnnotationLoadBalancerTLSPorts = annotationPrefix + "tls-ports"
+
+	// ServiceAnnotationLoadBalancerHealthCheckProtocol sets the health check protocol
+	ServiceAnnotationLoadBalancerHealthCheckProtocol = annotationPrefix + "healthcheck-protocol"
+	// ServiceAnnotationLoadBalancerHealthCheckPath sets the path of HTTP(S) health checks
+	ServiceAnnotationLoadBalancerHealthCheckPath = annotationPrefix + "healthcheck-path"
+	// ServiceAnnotationLoadBalancerHealthCheckInterval sets the health check interval in seconds
+	ServiceAnnotationLoadBalancerHealthCheckInterval = annotationPrefix + "healthcheck-interval"
+	// ServiceAnnotationLoadBalancerHealthCheckTimeout sets the health check timeout in seconds
+	ServiceAnnotationLoadBalancerHealthCheckTimeout = annotationPrefix + "healthcheck-timeout"
+	// ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold sets the successful checks before a backend is healthy
+	ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold = annotationPrefix + "healthcheck-healthy-threshold"
+	// ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold sets the failed checks before a backend is unhealthy
+	ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold = annotationPrefix + "healthcheck-unhealthy-threshold"
+)
+
+// Balancing algorithms supported by the mgmt API
+const (
+	AlgorithmRoundRobin       = "round-robin"
+	AlgorithmLeastConnections = "least-connections"
+	AlgorithmSourceIP         = "source-ip"
+)
+
+// Health check protocols supported by the mgmt API
+const (
+	HealthCheckProtocolTCP   = "tcp"
+	HealthCheckProtocolHTTP  = "http"
+	HealthCheckProtocolHTTPS = "https"
+)
+
+// Retain policies applied when the Service is deleted
+const (
+	// RetainPolicyDelete deletes the ingress, the default unless the ingress was adopted
+	RetainPolicyDelete = "Delete"
+	// RetainPolicyRetain releases the ingress: its backends and ownership tags are removed but it is kept
+	RetainPolicyRetain = "Retain"
+	// RetainPolicyRetainIP deletes the ingress but keeps its public IPs reserved in the tenant pool
+	RetainPolicyRetainIP = "RetainIP"
+)
+
+// serviceAnnotations holds the validated vcloud annotations of a Service
+type serviceAnnotations struct {
+	Internal                  bool
+	Network                   string
+	Subnet                    string
+	Algorithm                 string
+	IdleTimeout               int32
+	ConnectionDrainingTimeout *int32
+	ProxyProtocol             bool
+	HealthCheck               *LoadBalancerHealthCheck
+	LoadBalancerIP            string
+	ReservedIP                string
+	AdoptIngress              string
+	SharingKey                string
+	RetainPolicy              string
+	DeletionProtection        bool
+	TLS                       *tlsOptions
+	NodeSelector              string
+	ZonePolicy                string
+}
+
+// annotationParser collects the errors of all invalid annotations of a Service
+type annotationParser struct {
+	annotations map[string]string
+	errs        []error
+}
+
+// parseServiceAnnotations parses and validates the vcloud annotations of a Service
+func parseServiceAnnotations(service *v1.Service) (*serviceAnnotations, error) {
+	p := &annotationParser{annotations: service.Annotations}
+
+	result := &serviceAnnotations{
+		Internal:                  p.parseBool(ServiceAnnotationLoadBalancerInternal),
+		Network:                   p.parseName(ServiceAnnotationLoadBalancerNetwork),
+		Subnet:                    p.parseName(ServiceAnnotationLoadBalancerSubnet),
+		Algorithm:                 p.parseEnum(ServiceAnnotationLoadBalancerAlgorithm, AlgorithmRoundRobin, AlgorithmLeastConnections, AlgorithmSourceIP),
+		IdleTimeout:               p.parseInt32(ServiceAnnotationLoadBalancerIdleTimeout, 1, 3600),
+		ConnectionDrainingTimeout: p.parseOptionalInt32(ServiceAnnotationLoadBalancerConnectionDrainingTimeout, 0, 3600),
+		ProxyProtocol:             p.parseBool(ServiceAnnotationLoadBalancerProxyProtocol),
+	}
+
+	healthCheck := &LoadBalancerHealthCheck{
+		Protocol:           p.parseEnum(ServiceAnnotationLoadBalancerHealthCheckProtocol, HealthCheckProtocolTCP, HealthCheckProtocolHTTP, HealthCheckProtocolHTTPS),
+		Path:               p.parsePath(ServiceAnnotationLoadBalancerHealthCheckPath),
+		Interval:           p.parseInt32(ServiceAnnotationLoadBalancerHealthCheckInterval, 1, 300),
+		Timeout:            p.parseInt32(ServiceAnnotationLoadBalancerHealthCheckTimeout, 1, 300),
+		HealthyThreshold:   p.parseInt32(ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold, 1, 10),
+		UnhealthyThreshold: p.parseInt32(ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold, 1, 10),
+	}
+	if healthCheck.Path != "" && healthCheck.Protocol == "" {
+		healthCheck.Protocol = HealthCheckProtocolHTTP
+	}
+	if healthCheck.Path != "" && healthCheck.Protocol == HealthCheckProtocolTCP {
+		p.errs = append(p.errs, fmt.Errorf("%s is only valid for %q or %q health checks",
+			ServiceAnnotationLoadBalancerHealthCheckPath, HealthCheckProtocolHTTP, HealthCheckProtocolHTTPS))
+	}
+	if healthCheck.Timeout != 0 && healthCheck.Interval != 0 && healthCheck.Timeout > healthCheck.Interval {
+		p.errs = append(p.errs, fmt.Errorf("%s must not be greater than %s",
+			ServiceAnnotationLoadBalancerHealthCheckTimeout, ServiceAnnotationLoadBalancerHealthCheckInterval))
+	}
+	if *healthCheck != (LoadBalancerHealthCheck{}) {
+		result.HealthCheck = healthCheck
+	}
+
+	result.LoadBalancerIP = p.parseIP(ServiceAnnotationLoadBalancerIP)
+	result.ReservedIP = p.parseName(ServiceAnnotationLoadBalancerReservedIP)
+	if specIP := strings.TrimSpace(service.Spec.LoadBalancerIP); specIP != "" {
+		switch {
+		case result.LoadBalancerIP == "":
+			if net.ParseIP(specIP) == nil {
+				p.errs = append(p.errs, fmt.Errorf("spec.loadBalancerIP must be an IP address, got %q", specIP))
+			}
+			result.LoadBalancerIP = specIP
+		case result.LoadBalancerIP != specIP:
+			p.errs = append(p.errs, fmt.Errorf("%s %q conflicts with spec.loadBalancerIP %q", ServiceAnnotationLoadBalancerIP, result.LoadBalancerIP, specIP))
+		}
+	}
+	if result.LoadBalancerIP != "" && result.ReservedIP != "" {
+		p.errs = append(p.errs, fmt.Errorf("%s cannot be combined with a requested IP", ServiceAnnotationLoadBalancerReservedIP))
+	}
+
+	result.AdoptIngress = p.parseName(ServiceAnnotationLoadBalancerAdopt)
+	result.SharingKey = p.parseName(ServiceAnnotationLoadBalancerSharingKey)
+	if result.AdoptIngress != "" && result.SharingKey != "" {
+		p.errs = append(p.errs, fmt.Errorf("%s cannot be combined with %s", ServiceAnnotationLoadBalancerSharingKey, ServiceAnnotationLoadBalancerAdopt))
+	}
+	result.RetainPolicy = p.parseEnum(ServiceAnnotationLoadBalancerRetainPolicy, RetainPolicyDelete, RetainPolicyRetain, RetainPolicyRetainIP)
+	result.DeletionProtection = p.parseBool(ServiceAnnotationLoadBalancerDeletionProtection)
+	result.TLS = p.parseTLS(service)
+	if selector := p.parseSelector(ServiceAnnotationLoadBalancerNodeSelector); selector != nil {
+		result.NodeSelector = selector.String()
+	}
+	result.ZonePolicy = p.parseEnum(ServiceAnnotationLoadBalancerZonePolicy, ZonePolicyPreferSameZone, ZonePolicySpread)
+
+	if len(p.errs) > 0 {
+		return nil, utilerrors.NewAggregate(p.errs)
+	}
+	return result, nil
+}
+
+// adoptedIngressName returns the ingress named by the adopt annotation, "" if unset or invalid
+func adoptedIngressName(service *v1.Service) string {
+	p := &annotationParser{annotations: service.Annotations}
+	return p.parseName(ServiceAnnotationLoadBalancerAdopt)
+}
+
+// getSharingKey returns the sharing key of the Service, "" if unset, invalid or combined with adoption
+func getSharingKey(service *v1.Service) string {
+	if adoptedIngressName(service) != "" {
+		return ""
+	}
+	p := &annotationParser{annotations: service.Annotations}
+	return p.parseName(ServiceAnnotationLoadBalancerSharingKey)
+}
+
+// deletionOptions holds the annotations deciding how the load balancer of a Service is deleted
+type deletionOptions struct {
+	RetainPolicy       string
+	DeletionProtection bool
+}
+
+// getDeletionOptions returns the deletion options of the Service, adopted ingresses are retained by default.
+// Other annotations are not validated, so a Service with an unrelated invalid annotation can still be deleted.
+func getDeletionOptions(service *v1.Service) (*deletionOptions, error) {
+	p := &annotationParser{annotations: service.Annotations}
+	result := &deletionOptions{
+		RetainPolicy:       p.parseEnum(ServiceAnnotationLoadBalancerRetainPolicy, RetainPolicyDelete, RetainPolicyRetain, RetainPolicyRetainIP),
+		DeletionProtection: p.parseBool(ServiceAnnotationLoadBalancerDeletionProtection),
+	}
+	if len(p.errs) > 0 {
+		return nil, utilerrors.NewAggregate(p.errs)
+	}
+
+	if result.RetainPolicy == "" {
+		result.RetainPolicy = RetainPolicyDelete
+		if adoptedIngressName(service) != "" {
+			result.RetainPolicy = RetainPolicyRetain
+		}
+	}
+	return result, nil
+}
+
+// getTLSOptions returns the TLS options of the Service, nil if it does not terminate TLS
+func getTLSOptions(service *v1.Service) (*tlsOptions, error) {
+	p := &annotationParser{annotations: service.Annotations}
+	result := p.parseTLS(service)
+	if len(p.errs) > 0 {
+		return nil, utilerrors.NewAggregate(p.errs)
+	}
+	return result, nil
+}
+
+// getNodeSelector returns the selector of the backend nodes of the Service, nil if all nodes are used
+func getNodeSelector(service *v1.Service) (labels.Selector, error) {
+	p := &annotationParser{annotations: service.Annotations}
+	result := p.parseSelector(ServiceAnnotationLoadBalancerNodeSelector)
+	if len(p.errs) > 0 {
+		return nil, utilerrors.NewAggregate(p.errs)
+	}
+	return result, nil
+}
+
+// lookup returns the trimmed annotation value and whether it is set
+func (p *annotationParser) lookup(key string) (string, bool) {
+	value, ok := p.annotations[key]
+	return strings.TrimSpace(value), ok
+}
+
+// parseBool parses a boolean annotation, unset means false
+func (p *annotationParser) parseBool(key string) bool {
+	value, ok := p.lookup(key)
+	if !ok {
+		return false
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be \"true\" or \"false\", got %q", key, value))
+		return false
+	}
+	return result
+}
+
+// parseOptionalInt32 parses an integer annotation within [minValue, maxValue], unset means nil
+func (p *annotationParser) parseOptionalInt32(key string, minValue, maxValue int32) *int32 {
+	value, ok := p.lookup(key)
+	if !ok {
+		return nil
+	}
+	result, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || int32(result) < minValue || int32(result) > maxValue {
+		p.errs = append(p.errs, fmt.Errorf("%s must be an integer between %d and %d, got %q", key, minValue, maxValue, value))
+		return nil
+	}
+	r := int32(result)
+	return &r
+}
+
+// parseInt32 parses an integer annotation within [minValue, maxValue], unset means 0
+func (p *annotationParser) parseInt32(key string, minValue, maxValue int32) int32 {
+	if result := p.parseOptionalInt32(key, minValue, maxValue); result != nil {
+		return *result
+	}
+	return 0
+}
+
+// parseEnum parses an annotation that must be one of the allowed values, ignoring case, unset means ""
+func (p *annotationParser) parseEnum(key string, allowed ...string) string {
+	value, ok := p.lookup(key)
+	if !ok {
+		return ""
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return a
+		}
+	}
+	p.errs = append(p.errs, fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+	return ""
+}
+
+// parseSelector parses an annotation holding a non-empty label selector, unset means nil
+func (p *annotationParser) parseSelector(key string) labels.Selector {
+	value, ok := p.lookup(key)
+	if !ok {
+		return nil
+	}
+	selector, err := labels.Parse(value)
+	if err == nil && selector.Empty() {
+		err = fmt.Errorf("selector is empty")
+	}
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be a label selector, got %q: %v", key, value, err))
+		return nil
+	}
+	return selector
+}
+
+// parseList parses a comma separated annotation, empty entries are ignored and unset means nil
+func (p *annotationParser) parseList(key string) []string {
+	value, ok := p.lookup(key)
+	if !ok {
+		return nil
+	}
+	var result []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// parseTLS parses the TLS certificates and the TLS ports, which must be TCP ports of the service.
+// It returns nil if no certificate is set.
+func (p *annotationParser) parseTLS(service *v1.Service) *tlsOptions {
+	result := &tlsOptions{}
+	for _, entry := range p.parseList(ServiceAnnotationLoadBalancerTLSCertificates) {
+		if id, ok := strings.CutPrefix(entry, vcloudCertificatePrefix); ok {
+			if id == "" || strings.ContainsAny(id, "/ \t") {
+				p.errs = append(p.errs, fmt.Errorf("%s must hold a vcloud certificate ID after %q, got %q",
+					ServiceAnnotationLoadBalancerTLSCertificates, vcloudCertificatePrefix, entry))
+				continue
+			}
+			result.Certificates = append(result.Certificates, certificateRef{ID: id})
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(entry); len(errs) > 0 {
+			p.errs = append(p.errs, fmt.Errorf("%s must list Secret names or vcloud certificate IDs, got %q: %s",
+				ServiceAnnotationLoadBalancerTLSCertificates, entry, strings.Join(errs, ", ")))
+			continue
+		}
+		result.Certificates = append(result.Certificates, certificateRef{Secret: entry})
+	}
+
+	ports := p.parseList(ServiceAnnotationLoadBalancerTLSPorts)
+	for _, port := range ports {
+		svcPort := findServicePort(service, port)
+		switch {
+		case svcPort == nil:
+			p.errs = append(p.errs, fmt.Errorf("%s lists port %q, which is not a port of the service", ServiceAnnotationLoadBalancerTLSPorts, port))
+		case svcPort.Protocol != v1.ProtocolTCP:
+			p.errs = append(p.errs, fmt.Errorf("%s lists port %q, TLS can only be terminated on TCP ports", ServiceAnnotationLoadBalancerTLSPorts, port))
+		}
+	}
+	if len(result.Certificates) == 0 {
+		if len(ports) > 0 {
+			p.errs = append(p.errs, fmt.Errorf("%s requires %s", ServiceAnnotationLoadBalancerTLSPorts, ServiceAnnotationLoadBalancerTLSCertificates))
+		}
+		return nil
+	}
+	result.Ports = ports
+	return result
+}
+
+// parseIP parses an annotation holding an IP address, unset means ""
+func (p *annotationParser) parseIP(key string) string {
+	value, ok := p.lookup(key)
+	if !ok {
+		return ""
+	}
+	if net.ParseIP(value) == nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be an IP address, got %q", key, value))
+		return ""
+	}
+	return value
+}
+
+// parseName parses an annotation holding a DNS-1123 label, unset means ""
+func (p *annotationParser) parseName(key string) string {
+	value, ok := p.lookup(key)
+	if !ok {
+		return ""
+	}
+	if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+		p.errs = append(p.errs, fmt.Errorf("%s must be a valid name, got %q: %s", key, value, strings.Join(errs, ", ")))
+		return ""
+	}
+	return value
+}
+
+// parsePath parses an annotation holding an absolute URL path, unset means ""
+func (p *annotationParser) parsePath(key string) string {
+	value, ok := p.lookup(key)
+	if !ok {
+		return ""
+	}
+	if !strings.HasPrefix(value, "/") || strings.ContainsAny(value, " \t\n") {
+		p.errs = append(p.errs, fmt.Errorf("%s must be an absolute path, got %q", key, value))
+		return ""
+	}
+	return value
+}
@@ -70,6 +70,14 @@ func (c *instanceCache) get(ctx context.Context, instanceID string) (*InstanceIn
 	instances := &VCloudInstances{provider: c.provider, cache: c}
 	info, err := instances.GetInstanceInfo(ctx, instanceID)
 	if err != nil {
+		if exists && c.isServableStale(entry) {
+			klog.Warningf("Cache.get: failed to get instance info from API for %s, serving stale entry from %v: %v",
+				instanceID, entry.timestamp.Format(time.RFC3339), err)
+			instanceCacheStaleServed.Inc()
+			stale := *entry.info
+			stale.Stale = true
+			return &stale, nil
+		}
 		klog.Errorf("Cache.get: failed to get instance info from API for %s: %v", instanceID, err)
 		return nil, err
 	}
@@ -107,17 +115,43 @@ func (c *instanceCache) isExpired(entry *cacheEntry) bool {
 	return time.Since(entry.timestamp) > entry.ttl
 }
 
+// isServableStale checks if an expired cache entry may still be served because the API failed
+func (c *instanceCache) isServableStale(entry *cacheEntry) bool {
+	if !c.provider.cacheStaleOnError {
+		return false
+	}
+	return time.Since(entry.timestamp) <= entry.ttl+c.provider.cacheMaxStaleness
+}
+
 // cleanupOldEntriesLocked removes expired entries (must be called with write lock held)
 func (c *instanceCache) cleanupOldEntriesLocked() {
 	klog.V(4).Info("Cleaning up expired cache entries")
 
 	for id, entry := range c.cache {
-		if c.isExpired(entry) {
+		if c.isExpired(entry) && !c.isServableStale(entry) {
 			delete(c.cache, id)
 		}
 	}
 }
 
+// set stores instance info received outside of get, e.g. from the instance watch
+func (c *instanceCache) set(instanceID string, info *InstanceInfo) {
+	ttl := instanceCacheTTL
+	if !info.Exists {
+		ttl = nonExistentCacheTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cache[instanceID] = &cacheEntry{
+		info:      info,
+		timestamp: time.Now(),
+		ttl:       ttl,
+	}
+	klog.V(5).Infof("Set cache entry for instance %s (exists=%t)", instanceID, info.Exists)
+}
+
 // invalidate removes an entry from the cache
 func (c *instanceCache) invalidate(instanceID string) {
 	c.mu.Lock()
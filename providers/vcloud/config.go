@@ -21,17 +21,42 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ForeignInstancePolicyError reports instances that belong to another cluster as an error
+	ForeignInstancePolicyError = "error"
+	// ForeignInstancePolicyNotFound reports instances that belong to another cluster as not found
+	ForeignInstancePolicyNotFound = "notfound"
+
+	// defaultCacheMaxStaleness is how long expired instance data may be served when stale-on-error is enabled
+	defaultCacheMaxStaleness = 5 * time.Minute
 )
 
 // VCloudConfig holds the configuration for the VCloud provider
 type VCloudConfig struct {
-	ClusterID     string
-	ClusterName   string
-	MgmtURL       string
-	ProviderToken string
+	ClusterID             string
+	ClusterName           string
+	MgmtURL               string
+	ProviderToken         string
+	ForeignInstancePolicy string
+	CacheStaleOnError     bool
+	CacheMaxStaleness     time.Duration
+	CallbackToken         string
+	WatchInstances        bool
+
+	// Default frontend networks and subnets of external and internal load balancers, the mgmt API
+	// default network if empty
+	ExternalNetwork string
+	ExternalSubnet  string
+	InternalNetwork string
+	InternalSubnet  string
 }
 
 // readConfig reads the cloud configuration from the specified reader
@@ -40,7 +65,10 @@ func readConfig(config io.Reader) (*VCloudConfig, error) {
 		return nil, fmt.Errorf("no vcloud config provided")
 	}
 
-	cfg := &VCloudConfig{}
+	cfg := &VCloudConfig{
+		ForeignInstancePolicy: ForeignInstancePolicyError,
+		CacheMaxStaleness:     defaultCacheMaxStaleness,
+	}
 	scanner := bufio.NewScanner(config)
 	inVCloudSection := false
 
@@ -78,6 +106,36 @@ func readConfig(config io.Reader) (*VCloudConfig, error) {
 				cfg.MgmtURL = value
 			case "PROVIDER_TOKEN":
 				cfg.ProviderToken = value
+			case "FOREIGN_INSTANCE_POLICY":
+				cfg.ForeignInstancePolicy = strings.ToLower(value)
+			case "CACHE_STALE_ON_ERROR":
+				staleOnError, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("CACHE_STALE_ON_ERROR must be a boolean: %v", err)
+				}
+				cfg.CacheStaleOnError = staleOnError
+			case "CACHE_MAX_STALENESS":
+				maxStaleness, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("CACHE_MAX_STALENESS must be a duration: %v", err)
+				}
+				cfg.CacheMaxStaleness = maxStaleness
+			case "CALLBACK_TOKEN":
+				cfg.CallbackToken = value
+			case "WATCH_INSTANCES":
+				watchInstances, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("WATCH_INSTANCES must be a boolean: %v", err)
+				}
+				cfg.WatchInstances = watchInstances
+			case "EXTERNAL_NETWORK":
+				cfg.ExternalNetwork = value
+			case "EXTERNAL_SUBNET":
+				cfg.ExternalSubnet = value
+			case "INTERNAL_NETWORK":
+				cfg.InternalNetwork = value
+			case "INTERNAL_SUBNET":
+				cfg.InternalSubnet = value
 			}
 		}
 	}
@@ -118,9 +176,40 @@ func validateConfig(cfg *VCloudConfig) error {
 	}
 
 	// Validate MGMT_URL is a valid URL
-	if _, err := url.Parse(cfg.MgmtURL); err != nil {
+	mgmtURL, err := url.Parse(cfg.MgmtURL)
+	if err != nil {
 		return fmt.Errorf("MGMT_URL must be a valid URL: %v", err)
 	}
+	if mgmtURL.Scheme == "" || mgmtURL.Host == "" {
+		return fmt.Errorf("MGMT_URL must be a valid URL: missing scheme or host in %q", cfg.MgmtURL)
+	}
+
+	if cfg.CacheMaxStaleness < 0 {
+		return fmt.Errorf("CACHE_MAX_STALENESS must not be negative")
+	}
+
+	// Validate the default networks and subnets are valid names
+	for key, value := range map[string]string{
+		"EXTERNAL_NETWORK": cfg.ExternalNetwork,
+		"EXTERNAL_SUBNET":  cfg.ExternalSubnet,
+		"INTERNAL_NETWORK": cfg.InternalNetwork,
+		"INTERNAL_SUBNET":  cfg.InternalSubnet,
+	} {
+		if value == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+			return fmt.Errorf("%s must be a valid name, got %q: %s", key, value, strings.Join(errs, ", "))
+		}
+	}
+
+	// Validate FOREIGN_INSTANCE_POLICY is a known policy
+	switch cfg.ForeignInstancePolicy {
+	case ForeignInstancePolicyError, ForeignInstancePolicyNotFound:
+	default:
+		return fmt.Errorf("FOREIGN_INSTANCE_POLICY must be one of %q or %q, got %q",
+			ForeignInstancePolicyError, ForeignInstancePolicyNotFound, cfg.ForeignInstancePolicy)
+	}
 
 	return nil
 }
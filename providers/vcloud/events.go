@@ -0,0 +1,137 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+)
+
+// Event reasons emitted by the provider
+const (
+	eventReasonForeignInstance       = "ForeignInstance"
+	eventReasonQuotaExceeded         = "QuotaExceeded"
+	eventReasonAuthenticationFailed  = "AuthenticationFailed"
+	eventReasonInstanceTransitioning = "InstanceTransitioning"
+	eventReasonInvalidAnnotation     = "InvalidAnnotation"
+	eventReasonInvalidSourceRanges   = "InvalidSourceRanges"
+
+	eventReasonLoadBalancerIPNotReserved = "LoadBalancerIPNotReserved"
+	eventReasonLoadBalancerIPUnavailable = "LoadBalancerIPUnavailable"
+	eventReasonIPFamilyUnavailable       = "IPFamilyUnavailable"
+
+	eventReasonLoadBalancerProvisioningStuck  = "LoadBalancerProvisioningStuck"
+	eventReasonLoadBalancerProvisioningFailed = "LoadBalancerProvisioningFailed"
+
+	eventReasonLoadBalancerRenamed  = "LoadBalancerRenamed"
+	eventReasonLoadBalancerAdopted  = "LoadBalancerAdopted"
+	eventReasonLoadBalancerReplaced = "LoadBalancerReplaced"
+
+	eventReasonLoadBalancerOwnershipConflict = "LoadBalancerOwnershipConflict"
+	eventReasonAdoptedLoadBalancerNotFound   = "AdoptedLoadBalancerNotFound"
+	eventReasonLoadBalancerReleased          = "LoadBalancerReleased"
+
+	eventReasonLoadBalancerIPRetained        = "LoadBalancerIPRetained"
+	eventReasonLoadBalancerDeletionProtected = "LoadBalancerDeletionProtected"
+
+	eventReasonLoadBalancerSharingConflict = "LoadBalancerSharingConflict"
+	eventReasonSharedLoadBalancerKept      = "SharedLoadBalancerKept"
+
+	eventReasonInvalidTLSCertificate  = "InvalidTLSCertificate"
+	eventReasonTLSCertificateUploaded = "TLSCertificateUploaded"
+	eventReasonTLSCertificateRotated  = "TLSCertificateRotated"
+	eventReasonTLSCertificateDeleted  = "TLSCertificateDeleted"
+
+	eventReasonTLSCertificateCleanupFailed = "TLSCertificateCleanupFailed"
+
+	eventReasonNoNodesSelected = "NoNodesSelected"
+
+	eventReasonUnsupportedProtocol = "UnsupportedProtocol"
+
+	eventReasonLoadBalancerRecreating        = "LoadBalancerRecreating"
+	eventReasonLoadBalancerRecreated         = "LoadBalancerRecreated"
+	eventReasonLoadBalancerRecreationBlocked = "LoadBalancerRecreationBlocked"
+)
+
+// APIError is returned when the mgmt API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error returns the status code and response body
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+// newAPIError reads the response body into an APIError
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+}
+
+// isQuotaExceeded checks if the mgmt API rejected a request because a tenant quota is exhausted
+func isQuotaExceeded(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusPaymentRequired ||
+		(apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && strings.Contains(strings.ToLower(apiErr.Body), "quota"))
+}
+
+// isAuthFailure checks if the mgmt API rejected the provider token
+func isAuthFailure(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) && !isQuotaExceeded(err)
+}
+
+// eventf records an event on the object once Initialize has set up the recorder
+func (p *VCloudProvider) eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	p.mu.RLock()
+	recorder := p.recorder
+	p.mu.RUnlock()
+
+	if recorder == nil {
+		klog.V(4).Infof("Event recorder not initialized, dropping %s event: %s", reason, fmt.Sprintf(messageFmt, args...))
+		return
+	}
+	recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// recordAPIError records an event on the object if the error is a cloud-side problem users can act on
+func (p *VCloudProvider) recordAPIError(object runtime.Object, operation string, err error) {
+	switch {
+	case isQuotaExceeded(err):
+		p.eventf(object, v1.EventTypeWarning, eventReasonQuotaExceeded, "%s failed, vcloud quota exceeded: %v", operation, err)
+	case isAuthFailure(err):
+		p.eventf(object, v1.EventTypeWarning, eventReasonAuthenticationFailed, "%s failed, vcloud rejected the provider token: %v", operation, err)
+	}
+}
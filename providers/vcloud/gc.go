@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+var _ cloudprovider.LoadBalancerGarbageCollector = &VCloudLoadBalancer{}
+
+// IngressSummary is an ingress of the cluster as returned by the list endpoint
+type IngressSummary struct {
+	Name      string            `json:"name"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	CreatedAt time.Time         `json:"createdAt,omitempty"`
+}
+
+// ListLoadBalancers returns the ingresses created for Services of the cluster. Ingresses not named
+// after the cluster were created outside the provider, or under the legacy naming scheme before their
+// Service was synced again, and are never returned, nor are ingresses tagged with another cluster ID or
+// released by the Retain policy.
+func (lb *VCloudLoadBalancer) ListLoadBalancers(ctx context.Context, clusterName string) ([]cloudprovider.LoadBalancerInfo, error) {
+	resp, err := lb.provider.Request(ctx, "GET", "/ingresses", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list load balancers: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var apiResp struct {
+		Status int `json:"status"`
+		Data   struct {
+			Ingresses []IngressSummary `json:"ingresses"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if clusterName == "" {
+		clusterName = lb.provider.clusterName
+	}
+	prefix := loadBalancerNamePrefix(clusterName)
+	var loadBalancers []cloudprovider.LoadBalancerInfo
+	for _, ingress := range apiResp.Data.Ingresses {
+		if !strings.HasPrefix(ingress.Name, prefix) {
+			klog.V(5).Infof("Skipping ingress %s, not created for the cluster", ingress.Name)
+			continue
+		}
+		if owner := lb.ownerConflict(ingress.Tags, nil); owner != "" {
+			klog.V(4).Infof("Skipping ingress %s, it belongs to %s", ingress.Name, owner)
+			continue
+		}
+		if isRetained(ingress.Tags) {
+			klog.V(4).Infof("Skipping ingress %s, it was retained on deletion of its service", ingress.Name)
+			continue
+		}
+		loadBalancers = append(loadBalancers, cloudprovider.LoadBalancerInfo{
+			Name:       ingress.Name,
+			ServiceUID: types.UID(ingress.Tags[TagServiceUID]),
+			CreatedAt:  ingress.CreatedAt,
+		})
+	}
+	return loadBalancers, nil
+}
+
+// DeleteLoadBalancer deletes an ingress of the cluster by name, nil if it does not exist
+func (lb *VCloudLoadBalancer) DeleteLoadBalancer(ctx context.Context, clusterName string, name string) error {
+	klog.V(2).Infof("Deleting orphaned load balancer %s", name)
+	lb.clearProvisioning(name)
+
+	path := fmt.Sprintf("/ingresses/%s", name)
+	current, _, err := lb.ingressRequest(ctx, nil, "GET", path, "Getting load balancer "+name, nil)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		klog.V(4).Infof("Load balancer %s already deleted", name)
+		return nil
+	}
+	if owner := lb.ownerConflict(ingressTags(current), nil); owner != "" {
+		return fmt.Errorf("load balancer %s belongs to %s, refusing to delete it", name, owner)
+	}
+	if isRetained(ingressTags(current)) {
+		return fmt.Errorf("load balancer %s was retained on deletion of its service, refusing to delete it", name)
+	}
+
+	resp, err := lb.provider.Request(ctx, "DELETE", path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete load balancer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 404 is OK - already deleted
+	if resp.StatusCode == 404 {
+		klog.V(4).Infof("Load balancer %s already deleted", name)
+		return nil
+	}
+
+	if resp.StatusCode != 200 {
+		return newAPIError(resp)
+	}
+
+	klog.V(2).Infof("Successfully deleted orphaned load balancer %s", name)
+	return nil
+}
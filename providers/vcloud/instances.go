@@ -19,8 +19,8 @@ package vcloud
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
@@ -28,6 +28,9 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// errForeignInstance is returned when an instance belongs to a different cluster
+var errForeignInstance = errors.New("instance belongs to a different cluster")
+
 // VCloudInstances implements the InstancesV2 interface for VCloud
 type VCloudInstances struct {
 	provider *VCloudProvider
@@ -66,6 +69,9 @@ type InstanceInfo struct {
 	Shutdown    bool
 	Metadata    *cloudprovider.InstanceMetadata
 	RawInstance *Instance
+
+	// Stale is set when the info was served from an expired cache entry because the API failed
+	Stale bool
 }
 
 // NewVCloudInstances creates a new VCloudInstances instance
@@ -88,9 +94,25 @@ func (i *VCloudInstances) InstanceExists(ctx context.Context, node *v1.Node) (bo
 	info, err := i.cache.get(ctx, providerID)
 	if err != nil {
 		klog.Errorf("InstanceExists: failed to get instance info for node %s (providerID=%s): %v", node.Name, providerID, err)
+		i.provider.recordAPIError(node, "Looking up instance "+providerID, err)
+		return false, err
+	}
+
+	// A missing instance leads to node deletion, so never decide that from stale data
+	if !info.Exists && info.Stale {
+		klog.Warningf("InstanceExists: only stale data available for node %s (providerID=%s), refusing to report it as not found", node.Name, providerID)
+		return false, fmt.Errorf("instance %s for node %s: mgmt API unavailable and cached data is stale", providerID, node.Name)
+	}
+
+	if notFound, err := i.applyForeignInstancePolicy("InstanceExists", node, providerID, info); notFound || err != nil {
 		return false, err
 	}
 
+	if !info.Exists && info.RawInstance != nil && !info.RawInstance.Owned {
+		klog.Warningf("InstanceExists: instance for node %s (providerID=%s) is gone but not owned by the cluster, reporting as existing", node.Name, providerID)
+		return true, nil
+	}
+
 	klog.V(3).Infof("InstanceExists: node %s (providerID=%s) exists=%t", node.Name, providerID, info.Exists)
 	return info.Exists, nil
 }
@@ -107,7 +129,14 @@ func (i *VCloudInstances) InstanceShutdown(ctx context.Context, node *v1.Node) (
 	info, err := i.cache.get(ctx, providerID)
 	if err != nil {
 		klog.Errorf("InstanceShutdown: failed to get instance info for node %s (providerID=%s): %v", node.Name, providerID, err)
+		i.provider.recordAPIError(node, "Looking up instance "+providerID, err)
+		return false, err
+	}
+
+	if notFound, err := i.applyForeignInstancePolicy("InstanceShutdown", node, providerID, info); err != nil {
 		return false, err
+	} else if notFound {
+		return false, cloudprovider.InstanceNotFound
 	}
 
 	if !info.Exists {
@@ -131,7 +160,14 @@ func (i *VCloudInstances) InstanceMetadata(ctx context.Context, node *v1.Node) (
 	info, err := i.cache.get(ctx, providerID)
 	if err != nil {
 		klog.Errorf("InstanceMetadata: failed to get instance info for node %s (providerID=%s): %v", node.Name, providerID, err)
+		i.provider.recordAPIError(node, "Looking up instance "+providerID, err)
+		return nil, err
+	}
+
+	if notFound, err := i.applyForeignInstancePolicy("InstanceMetadata", node, providerID, info); err != nil {
 		return nil, err
+	} else if notFound {
+		return nil, cloudprovider.InstanceNotFound
 	}
 
 	if !info.Exists {
@@ -139,10 +175,48 @@ func (i *VCloudInstances) InstanceMetadata(ctx context.Context, node *v1.Node) (
 		return nil, cloudprovider.InstanceNotFound
 	}
 
+	if info.RawInstance != nil && isInstanceTransitioning(info.RawInstance.State) {
+		klog.V(2).Infof("InstanceMetadata: instance for node %s (providerID=%s) is in transitional state %s", node.Name, providerID, info.RawInstance.State)
+		i.provider.eventf(node, v1.EventTypeNormal, eventReasonInstanceTransitioning, "Instance %s is in transitional state %s", providerID, info.RawInstance.State)
+	}
+
 	klog.V(3).Infof("InstanceMetadata: successfully retrieved metadata for node %s (providerID=%s)", node.Name, providerID)
 	return info.Metadata, nil
 }
 
+// checkClusterMembership verifies that the instance belongs to the configured cluster
+func (i *VCloudInstances) checkClusterMembership(info *InstanceInfo) error {
+	if info.RawInstance == nil {
+		return nil
+	}
+
+	clusterID := info.RawInstance.Metadata.Cluster.ID
+	if !strings.EqualFold(clusterID, i.provider.clusterID) {
+		return fmt.Errorf("%w: instance %s is in cluster %q, expected %q", errForeignInstance, info.RawInstance.ID, clusterID, i.provider.clusterID)
+	}
+
+	return nil
+}
+
+// applyForeignInstancePolicy checks the cluster membership of the instance, so InstanceExists,
+// InstanceShutdown and InstanceMetadata give the same answer for it. It returns notFound if a foreign instance is reported as
+// not found by FOREIGN_INSTANCE_POLICY, and an error if it is reported as an error.
+func (i *VCloudInstances) applyForeignInstancePolicy(caller string, node *v1.Node, providerID string, info *InstanceInfo) (bool, error) {
+	err := i.checkClusterMembership(info)
+	if err == nil {
+		return false, nil
+	}
+
+	i.provider.eventf(node, v1.EventTypeWarning, eventReasonForeignInstance, "Instance %s does not belong to this cluster: %v", providerID, err)
+	// Never report an unowned instance as gone, the lifecycle controller would delete the node
+	if i.provider.foreignInstancePolicy == ForeignInstancePolicyNotFound && info.RawInstance.Owned && !info.Stale {
+		klog.Warningf("%s: node %s (providerID=%s) %v, reporting as not found", caller, node.Name, providerID, err)
+		return true, nil
+	}
+	klog.Errorf("%s: node %s (providerID=%s): %v", caller, node.Name, providerID, err)
+	return false, err
+}
+
 // getProviderID extracts the provider ID from a node
 func (i *VCloudInstances) getProviderID(node *v1.Node) string {
 	if node.Spec.ProviderID != "" {
@@ -182,9 +256,9 @@ func (i *VCloudInstances) GetInstanceInfo(ctx context.Context, instanceID string
 	}
 
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		klog.Errorf("GetInstanceInfo: unexpected status code %d for instance %s: %s", resp.StatusCode, instanceID, string(body))
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+		apiErr := newAPIError(resp)
+		klog.Errorf("GetInstanceInfo: unexpected status code %d for instance %s: %s", apiErr.StatusCode, instanceID, apiErr.Body)
+		return nil, apiErr
 	}
 
 	// Parse response
@@ -203,12 +277,18 @@ func (i *VCloudInstances) GetInstanceInfo(ctx context.Context, instanceID string
 	instance := &apiResp.Data.Instance
 	klog.V(4).Infof("GetInstanceInfo: parsed instance data for %s: Name=%s, Status=%s, State=%s", instanceID, instance.Name, instance.Status, instance.State)
 
+	return newInstanceInfo(instanceID, instance), nil
+}
+
+// newInstanceInfo builds the instance information from an API instance
+func newInstanceInfo(instanceID string, instance *Instance) *InstanceInfo {
 	// Check if instance is terminated
 	if instance.Status == "terminated" {
 		klog.Infof("GetInstanceInfo: Instance %s is terminated (status=%s)", instanceID, instance.Status)
 		return &InstanceInfo{
-			Exists: false,
-		}, nil
+			Exists:      false,
+			RawInstance: instance,
+		}
 	}
 
 	// Determine if instance is shutdown
@@ -239,7 +319,7 @@ func (i *VCloudInstances) GetInstanceInfo(ctx context.Context, instanceID string
 		Shutdown:    shutdown,
 		Metadata:    metadata,
 		RawInstance: instance,
-	}, nil
+	}
 }
 
 // isInstanceShutdown determines if an instance is in shutdown state
@@ -254,3 +334,19 @@ func isInstanceShutdown(state string) bool {
 
 	return shutdownStates[state]
 }
+
+// isInstanceTransitioning determines if an instance is changing between states
+func isInstanceTransitioning(state string) bool {
+	transitionalStates := map[string]bool{
+		"PENDING":      true,
+		"POWERING_ON":  true,
+		"POWERING_OFF": true,
+		"REBOOTING":    true,
+		"SUSPENDING":   true,
+		"RESUMING":     true,
+		"MIGRATING":    true,
+		"RESIZING":     true,
+	}
+
+	return transitionalStates[state]
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+// LoadBalancerFrontend requests a frontend IP of the given family
+type LoadBalancerFrontend struct {
+	IPFamily string `json:"ipFamily"`
+}
+
+// LoadBalancerBackend is a node address serving the load balancer
+type LoadBalancerBackend struct {
+	Node     string `json:"node"`
+	IPFamily string `json:"ipFamily"`
+	Address  string `json:"address"`
+
+	// Zone and Weight are set from the node topology labels and capacity, see setBackendTopology
+	Zone   string `json:"zone,omitempty"`
+	Weight int32  `json:"weight,omitempty"`
+}
+
+// getServiceIPFamilies returns the IP families of the service in order of preference.
+// Services without families are treated as IPv4 single-stack, as before dual-stack support.
+func getServiceIPFamilies(service *v1.Service) []v1.IPFamily {
+	families := service.Spec.IPFamilies
+	if len(families) == 0 {
+		return []v1.IPFamily{v1.IPv4Protocol}
+	}
+
+	policy := service.Spec.IPFamilyPolicy
+	if policy == nil || *policy == v1.IPFamilyPolicySingleStack {
+		return families[:1]
+	}
+	return families
+}
+
+// getNodeAddress returns the first internal IP of the node in the given family
+func getNodeAddress(node *v1.Node, family v1.IPFamily) string {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ipFamilyOf(addr.Address) == family {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// ipFamilyOf returns the family of an IP address, "" if it is not a valid IP
+func ipFamilyOf(ip string) v1.IPFamily {
+	switch {
+	case netutils.IsIPv4String(ip):
+		return v1.IPv4Protocol
+	case netutils.IsIPv6String(ip):
+		return v1.IPv6Protocol
+	}
+	return ""
+}
+
+// buildBackends collects the node addresses of every service IP family and returns the families that
+// can be served. Secondary families without any node address are dropped unless dual-stack is required.
+func (lb *VCloudLoadBalancer) buildBackends(service *v1.Service, nodes []*v1.Node) ([]v1.IPFamily, []LoadBalancerBackend, error) {
+	families := getServiceIPFamilies(service)
+
+	// Order the nodes by name so the same nodes always produce the same request
+	nodes = append([]*v1.Node(nil), nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	var servable []v1.IPFamily
+	var backends []LoadBalancerBackend
+	for i, family := range families {
+		count := 0
+		for _, node := range nodes {
+			address := getNodeAddress(node, family)
+			if address == "" {
+				continue
+			}
+			backends = append(backends, LoadBalancerBackend{
+				Node:     node.Name,
+				IPFamily: string(family),
+				Address:  address,
+			})
+			count++
+		}
+
+		// Without nodes there is nothing to check yet, keep the requested families
+		if count == 0 && len(nodes) > 0 && i > 0 {
+			policy := service.Spec.IPFamilyPolicy
+			if policy != nil && *policy == v1.IPFamilyPolicyRequireDualStack {
+				lb.provider.eventf(service, v1.EventTypeWarning, eventReasonIPFamilyUnavailable,
+					"No node has an internal %s address, required by ipFamilyPolicy %s", family, *policy)
+				return nil, nil, fmt.Errorf("service %s/%s requires %s but no node has an internal %s address",
+					service.Namespace, service.Name, *policy, family)
+			}
+			klog.V(2).Infof("No node has an internal %s address, serving service %s/%s without it", family, service.Namespace, service.Name)
+			continue
+		}
+		servable = append(servable, family)
+	}
+
+	return servable, backends, nil
+}
@@ -21,17 +21,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	cloudprovider "k8s.io/cloud-provider"
+	"sort"
 	"strings"
+	"sync"
 
 	v1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
 	"k8s.io/klog/v2"
 )
 
+// PersistenceSourceIP sends the connections of a client IP to the same backend
+const PersistenceSourceIP = "source-ip"
+
+var _ cloudprovider.LoadBalancerSessionAffinity = &VCloudLoadBalancer{}
+
 // VCloudLoadBalancer implements the LoadBalancer interface for VCloud
 type VCloudLoadBalancer struct {
 	provider *VCloudProvider
+
+	// mu guards provisioning, the load balancers waiting for an ingress address
+	mu           sync.Mutex
+	provisioning map[string]*provisioningState
+
+	// sharingMu guards sharing, the locks serializing the operations per sharing key
+	sharingMu sync.Mutex
+	sharing   map[string]*sharingLock
 }
 
 // LoadBalancerRequest represents a request to create/update a load balancer
@@ -41,6 +56,35 @@ type LoadBalancerRequest struct {
 	Nodes     []string           `json:"nodes"`
 	Namespace string             `json:"namespace"`
 	Type      string             `json:"type"`
+
+	// Frontends and Backends per IP family, Nodes holds the backend addresses of the primary family
+	IPFamilyPolicy string                 `json:"ipFamilyPolicy,omitempty"`
+	Frontends      []LoadBalancerFrontend `json:"frontends"`
+	Backends       []LoadBalancerBackend  `json:"backends,omitempty"`
+	ZonePolicy     string                 `json:"zonePolicy,omitempty"`
+
+	// SourceRanges are the CIDRs allowed to reach the load balancer, empty allows all
+	SourceRanges []string `json:"sourceRanges"`
+
+	// Options set through Service annotations
+	Internal                  bool                     `json:"internal,omitempty"`
+	Network                   string                   `json:"network,omitempty"`
+	Subnet                    string                   `json:"subnet,omitempty"`
+	Algorithm                 string                   `json:"algorithm,omitempty"`
+	IdleTimeout               int32                    `json:"idleTimeout,omitempty"`
+	ConnectionDrainingTimeout *int32                   `json:"connectionDrainingTimeout,omitempty"`
+	ProxyProtocol             bool                     `json:"proxyProtocol,omitempty"`
+	HealthCheck               *LoadBalancerHealthCheck `json:"healthCheck,omitempty"`
+
+	// Persistence keeps the connections of a client on the same backend, set from spec.sessionAffinity
+	Persistence *LoadBalancerPersistence `json:"persistence,omitempty"`
+
+	// LoadBalancerIP is the public IP to bind, ReservedIP the name of the reserved IP it was resolved from
+	LoadBalancerIP string `json:"loadBalancerIP,omitempty"`
+	ReservedIP     string `json:"reservedIP,omitempty"`
+
+	// Tags tie the ingress to its Service and cluster, see ownershipTags
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // LoadBalancerPort represents a port configuration for the load balancer
@@ -51,18 +95,59 @@ type LoadBalancerPort struct {
 	Protocol    string `json:"protocol"`
 	NodePort    int32  `json:"nodePort,omitempty"`
 	AppProtocol string `json:"appProtocol,omitempty"`
+
+	// TLS is set on ports terminating TLS
+	TLS *LoadBalancerPortTLS `json:"tls,omitempty"`
+}
+
+// LoadBalancerHealthCheck represents the backend health check of the load balancer
+type LoadBalancerHealthCheck struct {
+	Protocol           string `json:"protocol,omitempty"`
+	Path               string `json:"path,omitempty"`
+	Port               int32  `json:"port,omitempty"`
+	Interval           int32  `json:"interval,omitempty"`
+	Timeout            int32  `json:"timeout,omitempty"`
+	HealthyThreshold   int32  `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int32  `json:"unhealthyThreshold,omitempty"`
+}
+
+// LoadBalancerPersistence represents the session persistence of the load balancer
+type LoadBalancerPersistence struct {
+	Type string `json:"type"`
+	// Timeout is how long in seconds a client sticks to its backend after its last connection
+	Timeout int32 `json:"timeout"`
 }
 
 // LoadBalancerResponse represents the API response for load balancer operations
 type LoadBalancerResponse struct {
 	Status int `json:"status"`
 	Data   struct {
-		Ingress []struct {
-			IP string `json:"ip"`
-		} `json:"ingress"`
+		Ingress []LoadBalancerIngress `json:"ingress"`
+		// Config is the configuration the ingress was created or last updated with
+		Config *LoadBalancerRequest `json:"config,omitempty"`
+		// State is the provisioning state of the ingress, Message explains failures
+		State   string `json:"state,omitempty"`
+		Message string `json:"message,omitempty"`
 	} `json:"data"`
 }
 
+// LoadBalancerIngress is a frontend IP or hostname of the load balancer
+type LoadBalancerIngress struct {
+	IP       string `json:"ip"`
+	IPFamily string `json:"ipFamily,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// IPMode is "VIP" when traffic keeps the load balancer IP as destination, "Proxy" when the load balancer proxies it
+	IPMode string                   `json:"ipMode,omitempty"`
+	Ports  []LoadBalancerPortStatus `json:"ports,omitempty"`
+}
+
+// LoadBalancerPortStatus is the status of a frontend port, Error is set if the port failed to provision
+type LoadBalancerPortStatus struct {
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	Error    string `json:"error,omitempty"`
+}
+
 // NewVCloudLoadBalancer creates a new VCloudLoadBalancer instance
 func NewVCloudLoadBalancer(provider *VCloudProvider) cloudprovider.LoadBalancer {
 	return &VCloudLoadBalancer{
@@ -75,100 +160,112 @@ func (lb *VCloudLoadBalancer) GetLoadBalancer(ctx context.Context, clusterName s
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
 	klog.V(4).Infof("Getting load balancer %s", lbName)
 
-	path := fmt.Sprintf("/ingresses/%s", lbName)
-	resp, err := lb.provider.Request(ctx, "GET", path, nil)
+	lbResp, _, _, err := lb.lookupIngress(ctx, service, lbName)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to get load balancer %s: %v", lbName, err)
+		return nil, false, err
 	}
-	defer resp.Body.Close()
 
 	// Handle 404 - load balancer doesn't exist
-	if resp.StatusCode == 404 {
+	if lbResp == nil {
 		klog.V(4).Infof("Load balancer %s not found", lbName)
 		return nil, false, nil
 	}
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, false, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Parse response
-	var lbResp LoadBalancerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&lbResp); err != nil {
-		return nil, false, fmt.Errorf("failed to decode response: %v", err)
-	}
-
-	// Build status
-	status = &v1.LoadBalancerStatus{}
-	for _, ingress := range lbResp.Data.Ingress {
-		status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{
-			IP: ingress.IP,
-		})
-	}
-
-	return status, true, nil
+	return buildLoadBalancerStatus(service, lbResp), true, nil
 }
 
-// GetLoadBalancerName returns the name of the load balancer
+// GetLoadBalancerName returns the name of the adopted ingress, or builds one with buildSharedLoadBalancerName
+// or buildLoadBalancerName.
+// The CLUSTER_NAME of the cloud config is used if the cluster name is empty.
 func (lb *VCloudLoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
-	// Format: {cluster-name}-ingress-{uid-prefix}-{service-name}
-	uid := strings.Split(string(service.UID), "-")
-	lbName := fmt.Sprintf("%s-ingress-%s", lb.provider.clusterName, uid[0])
-	return fmt.Sprintf("%s-%s", lbName, service.Name)
+	if adopted := adoptedIngressName(service); adopted != "" {
+		return adopted
+	}
+	if clusterName == "" {
+		clusterName = lb.provider.clusterName
+	}
+	if sharingKey := getSharingKey(service); sharingKey != "" {
+		return buildSharedLoadBalancerName(clusterName, service.Namespace, sharingKey)
+	}
+	return buildLoadBalancerName(clusterName, service)
 }
 
 // EnsureLoadBalancer creates a new load balancer or updates an existing one
 func (lb *VCloudLoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
 	klog.V(2).Infof("Ensuring load balancer %s for service %s/%s", lbName, service.Namespace, service.Name)
+	if err := lb.checkAdoptedName(clusterName, service); err != nil {
+		return nil, err
+	}
+	defer lb.lockSharing(service)()
 
 	// Build request
-	req := lb.buildLoadBalancerRequest(lbName, service, nodes)
-
-	// Marshal request
-	body, err := json.Marshal(req)
+	req, err := lb.buildRequest(ctx, lbName, service, nodes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, err
+	}
+	if err := lb.bindPublicIP(ctx, service, req); err != nil {
+		return nil, err
 	}
 
-	// Make request
-	resp, err := lb.provider.Request(ctx, "POST", "/ingresses", bytes.NewReader(body))
+	lbResp, header, servedTLS, err := lb.reconcileIngress(ctx, service, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create/update load balancer: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	// The request is renamed when a load balancer of the legacy naming scheme was adopted
+	lbName = req.Name
+	if err := lb.checkProvisioned(service, lbName, header, lbResp); err != nil {
+		return nil, err
 	}
-
-	// Parse response
-	var lbResp LoadBalancerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&lbResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+	if err := lb.removePreviousIngresses(ctx, clusterName, service, lbName); err != nil {
+		return nil, err
 	}
 
-	// Build status
-	status := &v1.LoadBalancerStatus{}
-	for _, ingress := range lbResp.Data.Ingress {
-		status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{
-			IP: ingress.IP,
-		})
+	// Certificates are only cleaned up for services terminating TLS now or before this sync
+	if servedTLS || referencesTLSSecrets(service) {
+		lb.cleanupCertificates(ctx, service, false)
 	}
 
 	klog.V(2).Infof("Successfully ensured load balancer %s", lbName)
-	return status, nil
+	return buildLoadBalancerStatus(service, lbResp), nil
 }
 
 // UpdateLoadBalancer updates the nodes serving the load balancer
 func (lb *VCloudLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
 	klog.V(2).Infof("Updating load balancer %s", lbName)
+	if err := lb.checkAdoptedName(clusterName, service); err != nil {
+		return err
+	}
+	defer lb.lockSharing(service)()
 
 	// Build update request
-	req := lb.buildLoadBalancerRequest(lbName, service, nodes)
+	req, err := lb.buildRequest(ctx, lbName, service, nodes)
+	if err != nil {
+		return err
+	}
+	if err := lb.bindPublicIP(ctx, service, req); err != nil {
+		return err
+	}
+
+	// Load balancers adopted under their legacy name are updated under it
+	current, _, name, err := lb.lookupIngress(ctx, service, lbName)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("failed to update load balancer %s: not found", lbName)
+	}
+	if err := lb.checkOwnership(service, name, current); err != nil {
+		return err
+	}
+	req.Name = name
+
+	// Node syncs never move the frontend, only EnsureLoadBalancer recreates the ingress
+	if config := current.Data.Config; config != nil {
+		req.Internal, req.Network, req.Subnet = config.Internal, config.Network, config.Subnet
+	}
 
 	// Marshal request
 	body, err := json.Marshal(req)
@@ -177,7 +274,7 @@ func (lb *VCloudLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterNam
 	}
 
 	// Make request
-	path := fmt.Sprintf("/ingresses/%s", lbName)
+	path := fmt.Sprintf("/ingresses/%s", name)
 	resp, err := lb.provider.Request(ctx, "PUT", path, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("failed to update load balancer: %v", err)
@@ -185,20 +282,113 @@ func (lb *VCloudLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterNam
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+		apiErr := newAPIError(resp)
+		lb.provider.recordAPIError(service, "Updating load balancer "+name, apiErr)
+		return apiErr
 	}
 
-	klog.V(2).Infof("Successfully updated load balancer %s", lbName)
+	klog.V(2).Infof("Successfully updated load balancer %s", name)
 	return nil
 }
 
-// EnsureLoadBalancerDeleted deletes the load balancer
+// EnsureLoadBalancerDeleted deletes, releases or keeps the public IPs of the load balancer according to
+// the retain policy of the service. Deletion-protected load balancers are left alone.
 func (lb *VCloudLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
+	if err := lb.checkAdoptedName(clusterName, service); err != nil {
+		return err
+	}
+	options, err := getDeletionOptions(service)
+	if err != nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidAnnotation, "Invalid load balancer annotations: %v", err)
+		return fmt.Errorf("invalid annotations on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	if options.DeletionProtection {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerDeletionProtected,
+			"Load balancer %s is protected from deletion, remove the %s annotation to delete it", lbName, ServiceAnnotationLoadBalancerDeletionProtection)
+		return fmt.Errorf("load balancer %s of service %s/%s is protected from deletion", lbName, service.Namespace, service.Name)
+	}
+
+	// A shared load balancer is only deleted with its last member
+	defer lb.lockSharing(service)()
+	kept, err := lb.releaseSharedIngress(ctx, service, lbName)
+	if err != nil {
+		return err
+	}
+	if kept {
+		if referencesTLSSecrets(service) {
+			lb.cleanupCertificates(ctx, service, true)
+		}
+		return nil
+	}
+
+	// Also delete the load balancer if it was never migrated from its legacy name
+	for _, name := range lb.loadBalancerNames(lbName, service) {
+		path := fmt.Sprintf("/ingresses/%s", name)
+		current, _, err := lb.ingressRequest(ctx, service, "GET", path, "Getting load balancer "+name, nil)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			klog.V(4).Infof("Load balancer %s already deleted", name)
+			lb.clearProvisioning(name)
+			continue
+		}
+		if err := lb.checkOwnership(service, name, current); err != nil {
+			return err
+		}
+		switch options.RetainPolicy {
+		case RetainPolicyRetain:
+			err = lb.releaseIngress(ctx, service, name)
+		case RetainPolicyRetainIP:
+			err = lb.deleteIngressRetainingIPs(ctx, service, name, current)
+		default:
+			err = lb.deleteIngress(ctx, service, name, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The certificates stay with a retained load balancer, the others go once no service uses them
+	if !referencesTLSSecrets(service) {
+		return nil
+	}
+	if options.RetainPolicy == RetainPolicyRetain {
+		return lb.retainCertificates(ctx, service)
+	}
+	lb.cleanupCertificates(ctx, service, true)
+	return nil
+}
+
+// deleteIngressRetainingIPs deletes the ingress and keeps its public IPs reserved in the tenant pool
+func (lb *VCloudLoadBalancer) deleteIngressRetainingIPs(ctx context.Context, service *v1.Service, lbName string, current *LoadBalancerResponse) error {
+	if err := lb.deleteIngress(ctx, service, lbName, true); err != nil {
+		return err
+	}
+
+	var addresses []string
+	for _, ingress := range current.Data.Ingress {
+		if ingress.IP != "" {
+			addresses = append(addresses, ingress.IP)
+		}
+	}
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerIPRetained,
+		"Deleted load balancer %s, its public IPs %s are kept reserved", lbName, strings.Join(addresses, ", "))
+	return nil
+}
+
+// deleteIngress deletes the ingress, nil if it does not exist. With retainIPs the mgmt API keeps the public
+// IPs of the ingress reserved in the tenant pool instead of returning them.
+func (lb *VCloudLoadBalancer) deleteIngress(ctx context.Context, service *v1.Service, lbName string, retainIPs bool) error {
 	klog.V(2).Infof("Deleting load balancer %s", lbName)
+	lb.clearProvisioning(lbName)
 
 	path := fmt.Sprintf("/ingresses/%s", lbName)
+	if retainIPs {
+		path += "?retainPublicIPs=true"
+	}
 	resp, err := lb.provider.Request(ctx, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete load balancer: %v", err)
@@ -212,8 +402,9 @@ func (lb *VCloudLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clu
 	}
 
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+		apiErr := newAPIError(resp)
+		lb.provider.recordAPIError(service, "Deleting load balancer "+lbName, apiErr)
+		return apiErr
 	}
 
 	klog.V(2).Infof("Successfully deleted load balancer %s", lbName)
@@ -221,19 +412,78 @@ func (lb *VCloudLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clu
 }
 
 // buildLoadBalancerRequest builds a load balancer request from service and nodes
-func (lb *VCloudLoadBalancer) buildLoadBalancerRequest(name string, service *v1.Service, nodes []*v1.Node) *LoadBalancerRequest {
-	// Extract node IPs
+func (lb *VCloudLoadBalancer) buildLoadBalancerRequest(name string, service *v1.Service, nodes []*v1.Node) (*LoadBalancerRequest, error) {
+	annotations, err := parseServiceAnnotations(service)
+	if err != nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidAnnotation, "Invalid load balancer annotations: %v", err)
+		return nil, fmt.Errorf("invalid annotations on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	sourceRanges, err := getSourceRanges(service)
+	if err != nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidSourceRanges, "Invalid load balancer source ranges: %v", err)
+		return nil, err
+	}
+
+	nodes, err = lb.selectNodes(service, annotations.NodeSelector, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	families, backends, err := lb.buildBackends(service, nodes)
+	if err != nil {
+		return nil, err
+	}
+	setBackendTopology(backends, nodes, annotations.ZonePolicy)
+
+	frontends := make([]LoadBalancerFrontend, 0, len(families))
+	for _, family := range families {
+		frontends = append(frontends, LoadBalancerFrontend{IPFamily: string(family)})
+	}
+
+	// Extract node IPs of the primary family
 	nodeIPs := make([]string, 0, len(nodes))
-	for _, node := range nodes {
-		for _, addr := range node.Status.Addresses {
-			if addr.Type == v1.NodeInternalIP {
-				nodeIPs = append(nodeIPs, addr.Address)
-				break
-			}
+	for _, backend := range backends {
+		if backend.IPFamily == string(families[0]) {
+			nodeIPs = append(nodeIPs, backend.Address)
 		}
 	}
 
-	// Build ports
+	var ipFamilyPolicy string
+	if service.Spec.IPFamilyPolicy != nil {
+		ipFamilyPolicy = string(*service.Spec.IPFamilyPolicy)
+	}
+
+	network := lb.provider.resolveFrontendNetwork(annotations)
+
+	return &LoadBalancerRequest{
+		Name:                      name,
+		Ports:                     buildPorts(service),
+		Nodes:                     nodeIPs,
+		Namespace:                 service.Namespace,
+		Type:                      string(service.Spec.Type),
+		IPFamilyPolicy:            ipFamilyPolicy,
+		Frontends:                 frontends,
+		Backends:                  backends,
+		ZonePolicy:                annotations.ZonePolicy,
+		SourceRanges:              sourceRanges,
+		Internal:                  annotations.Internal,
+		Network:                   network.Network,
+		Subnet:                    network.Subnet,
+		Algorithm:                 annotations.Algorithm,
+		IdleTimeout:               annotations.IdleTimeout,
+		ConnectionDrainingTimeout: annotations.ConnectionDrainingTimeout,
+		ProxyProtocol:             annotations.ProxyProtocol,
+		HealthCheck:               buildHealthCheck(service, annotations.HealthCheck),
+		Persistence:               buildPersistence(service),
+		LoadBalancerIP:            annotations.LoadBalancerIP,
+		ReservedIP:                annotations.ReservedIP,
+		Tags:                      lb.ownershipTags(service),
+	}, nil
+}
+
+// buildPorts builds the load balancer ports of the service
+func buildPorts(service *v1.Service) []LoadBalancerPort {
 	ports := make([]LoadBalancerPort, 0, len(service.Spec.Ports))
 	for _, svcPort := range service.Spec.Ports {
 		port := LoadBalancerPort{
@@ -257,11 +507,67 @@ func (lb *VCloudLoadBalancer) buildLoadBalancerRequest(name string, service *v1.
 		ports = append(ports, port)
 	}
 
-	return &LoadBalancerRequest{
-		Name:      name,
-		Ports:     ports,
-		Nodes:     nodeIPs,
-		Namespace: service.Namespace,
-		Type:      string(service.Spec.Type),
+	return ports
+}
+
+// buildHealthCheck builds the backend health check for the service's external traffic policy.
+// Local policy services are checked over HTTP against the kube-proxy health check node port, so
+// only nodes with local endpoints receive traffic. Cluster policy services default to TCP checks.
+func buildHealthCheck(service *v1.Service, annotated *LoadBalancerHealthCheck) *LoadBalancerHealthCheck {
+	healthCheck := &LoadBalancerHealthCheck{}
+	if annotated != nil {
+		*healthCheck = *annotated
+	}
+
+	if path, port := servicehelpers.GetServiceHealthCheckPathPort(service); port != 0 {
+		if annotated != nil && (annotated.Protocol != "" || annotated.Path != "") {
+			klog.V(2).Infof("Service %s/%s has externalTrafficPolicy Local, ignoring health check protocol and path annotations", service.Namespace, service.Name)
+		}
+		healthCheck.Protocol = HealthCheckProtocolHTTP
+		healthCheck.Path = path
+		healthCheck.Port = port
+		return healthCheck
+	}
+
+	if healthCheck.Protocol == "" {
+		healthCheck.Protocol = HealthCheckProtocolTCP
 	}
+	return healthCheck
+}
+
+// buildPersistence maps ClientIP session affinity to source IP persistence, nil without session affinity
+func buildPersistence(service *v1.Service) *LoadBalancerPersistence {
+	if service.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		return nil
+	}
+
+	timeout := int32(v1.DefaultClientIPServiceAffinitySeconds)
+	if config := service.Spec.SessionAffinityConfig; config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+		timeout = *config.ClientIP.TimeoutSeconds
+	}
+	return &LoadBalancerPersistence{
+		Type:    PersistenceSourceIP,
+		Timeout: timeout,
+	}
+}
+
+// SupportsSessionAffinity returns true, session affinity is applied as source IP persistence
+func (lb *VCloudLoadBalancer) SupportsSessionAffinity() bool {
+	return true
+}
+
+// getSourceRanges returns the sorted CIDRs allowed to reach the load balancer, or an empty list if all are allowed
+func getSourceRanges(service *v1.Service) ([]string, error) {
+	ipnets, err := servicehelpers.GetLoadBalancerSourceRanges(service)
+	if err != nil {
+		return nil, err
+	}
+
+	if servicehelpers.IsAllowAll(ipnets) {
+		return []string{}, nil
+	}
+
+	sourceRanges := ipnets.StringSlice()
+	sort.Strings(sourceRanges)
+	return sourceRanges, nil
 }
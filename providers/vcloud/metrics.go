@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// metricsSubsystem is the subsystem name used for the vcloud provider prometheus metrics.
+	metricsSubsystem = "vcloud_provider"
+)
+
+var (
+	instanceCacheStaleServed = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "instance_cache_stale_served_total",
+			Help:           "Number of times expired instance data was served from the cache because the mgmt API request failed.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	notificationsReceived = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "notifications_total",
+			Help:           "Number of change notifications received from the mgmt API, by kind and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind", "result"},
+	)
+	loadBalancerReconciles = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "load_balancer_reconciles_total",
+			Help:           "Number of load balancers ensured, by outcome (created, updated, unchanged or recreated).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+)
+
+var metricRegistration sync.Once
+
+// registerMetrics registers the vcloud provider metrics.
+func registerMetrics() {
+	metricRegistration.Do(func() {
+		legacyregistry.MustRegister(instanceCacheStaleServed)
+		legacyregistry.MustRegister(notificationsReceived)
+		legacyregistry.MustRegister(loadBalancerReconciles)
+	})
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxLoadBalancerNameLength is the longest ingress name accepted by the mgmt API, a DNS-1123 label
+	maxLoadBalancerNameLength = 63
+
+	// maxClusterNameLength bounds the cluster part of the name so the service part stays readable
+	maxClusterNameLength = 20
+
+	// loadBalancerNameHashLength is the number of hex characters of the service hash ending every name
+	loadBalancerNameHashLength = 10
+)
+
+// loadBalancerNamePrefix returns the prefix of all load balancer names of the cluster
+func loadBalancerNamePrefix(clusterName string) string {
+	return namePrefix(clusterName, "lb")
+}
+
+// namePrefix returns {cluster}-{kind}- for the names of the cluster's objects of a kind
+func namePrefix(clusterName, kind string) string {
+	cluster := sanitizeName(clusterName, maxClusterNameLength)
+	if cluster == "" {
+		cluster = "kubernetes"
+	}
+	return cluster + "-" + kind + "-"
+}
+
+// loadBalancerNameHash identifies the service independently of the cluster name and of truncation
+func loadBalancerNameHash(service *v1.Service) string {
+	sum := sha256.Sum256([]byte(service.Namespace + "/" + service.Name + "/" + string(service.UID)))
+	return hex.EncodeToString(sum[:])[:loadBalancerNameHashLength]
+}
+
+// sharedLoadBalancerNameHash identifies the services of a namespace sharing a load balancer
+func sharedLoadBalancerNameHash(namespace, sharingKey string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + sharingKey))
+	return hex.EncodeToString(sum[:])[:loadBalancerNameHashLength]
+}
+
+// buildLoadBalancerName returns {cluster}-lb-{namespace}-{name}-{hash}. The namespace and name are
+// lowercased, stripped of invalid characters and truncated so the name fits the mgmt API limit; the hash
+// of the namespace, name and UID keeps truncated and sanitized names apart.
+func buildLoadBalancerName(clusterName string, service *v1.Service) string {
+	return composeLoadBalancerName(clusterName, service.Namespace+"-"+service.Name, loadBalancerNameHash(service))
+}
+
+// buildSharedLoadBalancerName returns {cluster}-lb-{namespace}-{sharing-key}-{hash} for the services of the
+// namespace sharing a load balancer, with the hash of the namespace and sharing key
+func buildSharedLoadBalancerName(clusterName, namespace, sharingKey string) string {
+	return composeLoadBalancerName(clusterName, namespace+"-"+sharingKey, sharedLoadBalancerNameHash(namespace, sharingKey))
+}
+
+// composeLoadBalancerName joins the cluster prefix, the readable part truncated to fit and the hash
+func composeLoadBalancerName(clusterName, readable, hash string) string {
+	return composeName(loadBalancerNamePrefix(clusterName), readable, hash)
+}
+
+// composeName joins the prefix, the readable part truncated to fit and the hash
+func composeName(prefix, readable, hash string) string {
+	readable = sanitizeName(readable, maxLoadBalancerNameLength-len(prefix)-len(hash)-1)
+	if readable == "" {
+		return prefix + hash
+	}
+	return prefix + readable + "-" + hash
+}
+
+// sanitizeName lowercases s, replaces runs of characters outside [a-z0-9] with a dash and truncates it
+// to max characters without leading or trailing dashes
+func sanitizeName(s string, max int) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	name := strings.Trim(b.String(), "-")
+	if len(name) > max {
+		name = strings.TrimRight(name[:max], "-")
+	}
+	return name
+}
+
+// isLoadBalancerNameOf checks if the load balancer name is used by the service, whatever the cluster name
+func isLoadBalancerNameOf(name string, service *v1.Service, legacyClusterName string) bool {
+	if adopted := adoptedIngressName(service); adopted != "" {
+		return name == adopted
+	}
+	if sharingKey := getSharingKey(service); sharingKey != "" {
+		return strings.HasSuffix(name, "-"+sharedLoadBalancerNameHash(service.Namespace, sharingKey))
+	}
+	return strings.HasSuffix(name, "-"+loadBalancerNameHash(service)) || name == legacyLoadBalancerName(legacyClusterName, service)
+}
+
+// legacyLoadBalancerName returns the name load balancers were created with before names were hashed,
+// {cluster-name}-ingress-{uid-prefix}-{service-name} with the CLUSTER_NAME of the cloud config
+func legacyLoadBalancerName(clusterName string, service *v1.Service) string {
+	uid := strings.Split(string(service.UID), "-")
+	return fmt.Sprintf("%s-ingress-%s-%s", clusterName, uid[0], service.Name)
+}
+
+// loadBalancerNames returns the current name of the load balancer followed by its legacy name.
+// Adopted and shared ingresses only have their current name.
+func (lb *VCloudLoadBalancer) loadBalancerNames(name string, service *v1.Service) []string {
+	legacy := legacyLoadBalancerName(lb.provider.clusterName, service)
+	if legacy == name || adoptedIngressName(service) != "" || getSharingKey(service) != "" {
+		return []string{name}
+	}
+	return []string{name, legacy}
+}
+
+// lookupIngress gets the ingress of the service by name, falling back to its legacy name. It returns the
+// name the ingress was found under, or nil if the ingress does not exist under either name.
+func (lb *VCloudLoadBalancer) lookupIngress(ctx context.Context, service *v1.Service, name string) (*LoadBalancerResponse, http.Header, string, error) {
+	for _, candidate := range lb.loadBalancerNames(name, service) {
+		path := fmt.Sprintf("/ingresses/%s", candidate)
+		lbResp, header, err := lb.ingressRequest(ctx, service, "GET", path, "Getting load balancer "+candidate, nil)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if lbResp != nil {
+			return lbResp, header, candidate, nil
+		}
+	}
+	return nil, nil, name, nil
+}
+
+// migrateIngress renames an ingress created under the legacy naming scheme. If the mgmt API refuses the
+// rename, the ingress is adopted under its legacy name instead, which is returned as the name to use.
+func (lb *VCloudLoadBalancer) migrateIngress(ctx context.Context, service *v1.Service, legacy, name string) (*LoadBalancerResponse, http.Header, string, error) {
+	path := fmt.Sprintf("/ingresses/%s", legacy)
+	renamed, header, err := lb.ingressRequest(ctx, service, "PATCH", path, "Renaming load balancer "+legacy, map[string]string{"name": name})
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && isRenameRefused(apiErr.StatusCode) {
+		klog.V(2).Infof("Mgmt API refused to rename load balancer %s to %s (%d), adopting it", legacy, name, apiErr.StatusCode)
+		lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerAdopted,
+			"Managing load balancer %s under its previous name, it could not be renamed to %s", legacy, name)
+		return nil, nil, legacy, nil
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if renamed == nil {
+		return nil, nil, "", fmt.Errorf("failed to rename load balancer %s: not found", legacy)
+	}
+
+	klog.V(2).Infof("Renamed load balancer %s to %s", legacy, name)
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerRenamed, "Renamed load balancer %s to %s", legacy, name)
+	return renamed, header, name, nil
+}
+
+// isRenameRefused checks if the status code means the ingress cannot be renamed, as opposed to a failure
+func isRenameRefused(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusConflict, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// previousLoadBalancerNames returns the names the load balancer of the service had before it was adopted
+// or shared, under which an ingress of the service may be left behind
+func (lb *VCloudLoadBalancer) previousLoadBalancerNames(clusterName, name string, service *v1.Service) []string {
+	if clusterName == "" {
+		clusterName = lb.provider.clusterName
+	}
+	var names []string
+	for _, previous := range []string{buildLoadBalancerName(clusterName, service), legacyLoadBalancerName(lb.provider.clusterName, service)} {
+		if previous != name && (len(names) == 0 || names[0] != previous) {
+			names = append(names, previous)
+		}
+	}
+	return names
+}
+
+// removePreviousIngresses removes the ingresses tagged with the service under its previous names, left
+// behind when it moved to an adopted or shared load balancer, according to its retain policy. The ingress was created by the
+// provider, so it is deleted unless a retain policy is set explicitly.
+func (lb *VCloudLoadBalancer) removePreviousIngresses(ctx context.Context, clusterName string, service *v1.Service, name string) error {
+	if adoptedIngressName(service) == "" && getSharingKey(service) == "" {
+		return nil
+	}
+	options, err := getDeletionOptions(service)
+	if err != nil {
+		return fmt.Errorf("invalid annotations on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerRetainPolicy]; !ok {
+		options.RetainPolicy = RetainPolicyDelete
+	}
+
+	for _, previous := range lb.previousLoadBalancerNames(clusterName, name, service) {
+		path := fmt.Sprintf("/ingresses/%s", previous)
+		current, _, err := lb.ingressRequest(ctx, service, "GET", path, "Getting load balancer "+previous, nil)
+		if err != nil {
+			return err
+		}
+		// Only ingresses tagged with the service are removed, untagged ones may have been created by hand
+		tags := ingressTags(current)
+		if current == nil || tags[TagServiceUID] != string(service.UID) || lb.ownerConflict(tags, service) != "" {
+			continue
+		}
+		if options.DeletionProtection {
+			lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerDeletionProtected,
+				"Load balancer %s was replaced by %s but is protected from deletion, remove the %s annotation to delete it",
+				previous, name, ServiceAnnotationLoadBalancerDeletionProtection)
+			continue
+		}
+
+		klog.V(2).Infof("Load balancer %s of service %s/%s was replaced by %s", previous, service.Namespace, service.Name, name)
+		lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerReplaced,
+			"Load balancer %s replaces %s, removing it according to the %s retain policy", name, previous, options.RetainPolicy)
+		switch options.RetainPolicy {
+		case RetainPolicyRetain:
+			err = lb.releaseIngress(ctx, service, previous)
+		case RetainPolicyRetainIP:
+			err = lb.deleteIngressRetainingIPs(ctx, service, previous, current)
+		default:
+			err = lb.deleteIngress(ctx, service, previous, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
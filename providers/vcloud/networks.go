@@ -0,0 +1,153 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// frontendNetwork is the network and subnet the frontend IP of a load balancer is allocated from,
+// empty fields leaving the choice to the mgmt API
+type frontendNetwork struct {
+	Network string
+	Subnet  string
+}
+
+// resolveFrontendNetwork returns the frontend network of the service. The cloud config default of the
+// internal or external kind applies unless overridden; an annotated network drops the default subnet,
+// which belongs to the default network.
+func (p *VCloudProvider) resolveFrontendNetwork(annotations *serviceAnnotations) frontendNetwork {
+	network := p.externalNetwork
+	if annotations.Internal {
+		network = p.internalNetwork
+	}
+	if annotations.Network != "" && annotations.Network != network.Network {
+		network = frontendNetwork{Network: annotations.Network}
+	}
+	if annotations.Subnet != "" {
+		network.Subnet = annotations.Subnet
+	}
+	return network
+}
+
+// frontendChanges returns the changes between the current and desired frontend of a load balancer that
+// need a new frontend IP, empty if none. A network or subnet missing from either configuration keeps the
+// current one: the mgmt API chose it, or the desired configuration no longer asks for one, so neither
+// moves the load balancer.
+func frontendChanges(current, desired *LoadBalancerRequest) []string {
+	if current == nil {
+		return nil
+	}
+	var changes []string
+	if current.Internal != desired.Internal {
+		changes = append(changes, fmt.Sprintf("internal %t -> %t", current.Internal, desired.Internal))
+	}
+	if current.Network != "" && desired.Network != "" && current.Network != desired.Network {
+		changes = append(changes, fmt.Sprintf("network %q -> %q", current.Network, desired.Network))
+	}
+	if current.Subnet != "" && desired.Subnet != "" && current.Subnet != desired.Subnet {
+		changes = append(changes, fmt.Sprintf("subnet %q -> %q", current.Subnet, desired.Subnet))
+	}
+	return changes
+}
+
+// keepFrontend keeps the current network and subnet of the load balancer where the mgmt API chose them or
+// the desired configuration names none, so the diff does not patch fields the mgmt API cannot change in
+// place
+func keepFrontend(current, desired *LoadBalancerRequest) {
+	if current == nil {
+		current = &LoadBalancerRequest{}
+	}
+	if current.Network == "" && desired.Network != "" {
+		klog.V(2).Infof("Load balancer %s has no recorded network, keeping it on its current network instead of %q", desired.Name, desired.Network)
+		desired.Network = ""
+	}
+	if desired.Network == "" {
+		desired.Network = current.Network
+	}
+	if current.Subnet == "" && desired.Subnet != "" {
+		klog.V(2).Infof("Load balancer %s has no recorded subnet, keeping it on its current subnet instead of %q", desired.Name, desired.Subnet)
+		desired.Subnet = ""
+	}
+	if desired.Subnet == "" {
+		desired.Subnet = current.Subnet
+	}
+}
+
+// recreateIngress deletes the ingress and creates it again on its new frontend network, since the frontend
+// IP cannot move between networks. The ingress is deleted according to the retain policy of the service;
+// adopted, shared, retained and deletion-protected ingresses are never recreated.
+func (lb *VCloudLoadBalancer) recreateIngress(ctx context.Context, service *v1.Service, req *LoadBalancerRequest, current *LoadBalancerResponse, changes []string) (*LoadBalancerResponse, http.Header, error) {
+	change := strings.Join(changes, ", ")
+
+	options, err := getDeletionOptions(service)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid annotations on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	if options.DeletionProtection {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerDeletionProtected,
+			"Load balancer %s must be recreated to change its frontend (%s), but it is protected from deletion, remove the %s annotation to recreate it",
+			req.Name, change, ServiceAnnotationLoadBalancerDeletionProtection)
+		return nil, nil, fmt.Errorf("load balancer %s of service %s/%s must be recreated to change its frontend (%s), but it is protected from deletion",
+			req.Name, service.Namespace, service.Name, change)
+	}
+	var blocked string
+	switch {
+	case adoptedIngressName(service) != "":
+		blocked = "it was adopted with the " + ServiceAnnotationLoadBalancerAdopt + " annotation"
+	case getSharingKey(service) != "":
+		blocked = "it is shared with the " + ServiceAnnotationLoadBalancerSharingKey + " annotation"
+	case options.RetainPolicy == RetainPolicyRetain:
+		blocked = "the " + RetainPolicyRetain + " policy keeps the ingress"
+	}
+	if blocked != "" {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerRecreationBlocked,
+			"Load balancer %s must be recreated to change its frontend (%s), but %s", req.Name, change, blocked)
+		return nil, nil, fmt.Errorf("load balancer %s of service %s/%s must be recreated to change its frontend (%s), but %s",
+			req.Name, service.Namespace, service.Name, change, blocked)
+	}
+
+	lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerRecreating,
+		"Recreating load balancer %s to change its frontend (%s), its IP will change", req.Name, change)
+	if options.RetainPolicy == RetainPolicyRetainIP {
+		err = lb.deleteIngressRetainingIPs(ctx, service, req.Name, current)
+	} else {
+		err = lb.deleteIngress(ctx, service, req.Name, false)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	created, header, err := lb.ingressRequest(ctx, service, "POST", "/ingresses", "Recreating load balancer "+req.Name, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if created == nil {
+		return nil, nil, fmt.Errorf("failed to recreate load balancer %s: not found", req.Name)
+	}
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonLoadBalancerRecreated,
+		"Recreated load balancer %s with its new frontend (%s)", req.Name, change)
+	loadBalancerReconciles.WithLabelValues(reconcileRecreated).Inc()
+	klog.V(2).Infof("Load balancer %s %s (%s)", req.Name, reconcileRecreated, change)
+	return created, header, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// selectNodes returns the nodes matching the node selector of the service. A selector matching none of
+// the nodes fails the sync instead of emptying the backend pool.
+func (lb *VCloudLoadBalancer) selectNodes(service *v1.Service, nodeSelector string, nodes []*v1.Node) ([]*v1.Node, error) {
+	if nodeSelector == "" || len(nodes) == 0 {
+		return nodes, nil
+	}
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %v", nodeSelector, err)
+	}
+
+	var selected []*v1.Node
+	for _, node := range nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			selected = append(selected, node)
+		}
+	}
+	if len(selected) == 0 {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonNoNodesSelected,
+			"None of the %d nodes matches the node selector %q, keeping the current backends", len(nodes), nodeSelector)
+		return nil, fmt.Errorf("none of the %d nodes matches the node selector %q of service %s/%s",
+			len(nodes), nodeSelector, service.Namespace, service.Name)
+	}
+
+	klog.V(4).Infof("Node selector %q of service %s/%s selects %d of %d nodes", nodeSelector, service.Namespace, service.Name, len(selected), len(nodes))
+	return selected, nil
+}
+
+// watchNodeLabels requeues the services with a node selector when a node starts or stops matching it.
+// The service controller only updates the backends when nodes are added or removed.
+func (p *VCloudProvider) watchNodeLabels(informer cache.SharedIndexInformer) {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			node, ok := newObj.(*v1.Node)
+			if !ok || labels.Equals(oldNode.Labels, node.Labels) {
+				return
+			}
+			if err := p.requeueNodeSelectorServices(oldNode, node); err != nil {
+				klog.Errorf("Failed to requeue the services selecting node %s: %v", node.Name, err)
+			}
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch node labels, node selectors will only be applied when services are synced: %v", err)
+	}
+}
+
+// requeueNodeSelectorServices requeues the LoadBalancer services whose node selector matches only one of
+// the old and new versions of the node
+func (p *VCloudProvider) requeueNodeSelectorServices(oldNode, node *v1.Node) error {
+	p.mu.RLock()
+	serviceLister := p.serviceLister
+	p.mu.RUnlock()
+
+	if serviceLister == nil {
+		klog.V(3).Infof("Service informer not set yet, not requeueing the services selecting node %s", node.Name)
+		return nil
+	}
+
+	services, err := serviceLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	for _, service := range services {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		selector, err := getNodeSelector(service)
+		if err != nil || selector == nil {
+			continue
+		}
+		if selector.Matches(labels.Set(oldNode.Labels)) == selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		klog.V(2).Infof("Requeueing service %s/%s after relabeling of node %s", service.Namespace, service.Name, node.Name)
+		p.requeueService(service)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// notificationPath is where the mgmt API posts change notifications
+	notificationPath = "/vcloud/notifications"
+
+	// callbackTokenHeader carries the CALLBACK_TOKEN on change notifications
+	callbackTokenHeader = "X-Callback-Token"
+
+	// maxNotificationSize limits the size of a notification body
+	maxNotificationSize = 64 * 1024
+
+	// NotificationKindInstance notifies about a change of an instance
+	NotificationKindInstance = "instance"
+	// NotificationKindIngress notifies about a change of an ingress
+	NotificationKindIngress = "ingress"
+)
+
+// Notification represents a change notification sent by the mgmt API
+type Notification struct {
+	Kind string `json:"kind"`
+	// ID is the instance ID of instance notifications
+	ID string `json:"id,omitempty"`
+	// Name is the ingress name of ingress notifications
+	Name string `json:"name,omitempty"`
+}
+
+// notificationHandler serves the change notification endpoint
+type notificationHandler struct {
+	provider *VCloudProvider
+}
+
+// newNotificationHandler creates a new change notification handler
+func newNotificationHandler(provider *VCloudProvider) http.Handler {
+	return &notificationHandler{
+		provider: provider,
+	}
+}
+
+// ServeHTTP authenticates and dispatches a change notification
+func (h *notificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(callbackTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.provider.callbackToken)) != 1 {
+		klog.Warningf("Rejected change notification from %s: invalid callback token", r.RemoteAddr)
+		notificationsReceived.WithLabelValues("", "unauthorized").Inc()
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var notification Notification
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNotificationSize)).Decode(&notification); err != nil {
+		notificationsReceived.WithLabelValues("", "invalid").Inc()
+		http.Error(w, fmt.Sprintf("failed to decode notification: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	klog.V(3).Infof("Received change notification: kind=%s id=%s name=%s", notification.Kind, notification.ID, notification.Name)
+
+	var err error
+	switch {
+	case notification.Kind == NotificationKindInstance && notification.ID != "":
+		err = h.provider.handleInstanceNotification(notification.ID)
+	case notification.Kind == NotificationKindIngress && notification.Name != "":
+		err = h.provider.handleIngressNotification(notification.Name)
+	default:
+		notificationsReceived.WithLabelValues(notification.Kind, "invalid").Inc()
+		http.Error(w, fmt.Sprintf("unsupported notification kind %q or missing id/name", notification.Kind), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		klog.Errorf("Failed to handle %s change notification: %v", notification.Kind, err)
+		notificationsReceived.WithLabelValues(notification.Kind, "error").Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	notificationsReceived.WithLabelValues(notification.Kind, "accepted").Inc()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleInstanceNotification invalidates the cached instance and requeues its node
+func (p *VCloudProvider) handleInstanceNotification(instanceID string) error {
+	instances, ok := p.instances.(*VCloudInstances)
+	if !ok {
+		return fmt.Errorf("instances interface does not support cache invalidation")
+	}
+	instances.cache.invalidate(instanceID)
+
+	return p.requeueInstanceNode(instanceID)
+}
+
+// requeueInstanceNode requeues the node backed by the instance
+func (p *VCloudProvider) requeueInstanceNode(instanceID string) error {
+	instances, ok := p.instances.(*VCloudInstances)
+	if !ok {
+		return fmt.Errorf("instances interface does not support node lookup")
+	}
+
+	p.mu.RLock()
+	nodeLister := p.nodeLister
+	p.mu.RUnlock()
+
+	if nodeLister == nil {
+		klog.V(3).Infof("Node informer not set yet, not requeueing node of instance %s", instanceID)
+		return nil
+	}
+
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	for _, node := range nodes {
+		if instances.getProviderID(node) != instanceID {
+			continue
+		}
+		klog.V(2).Infof("Requeueing node %s after change of instance %s", node.Name, instanceID)
+		p.requeueNode(node)
+		return nil
+	}
+
+	klog.V(3).Infof("No node found for instance %s", instanceID)
+	return nil
+}
+
+// handleIngressNotification requeues the services using the ingress
+func (p *VCloudProvider) handleIngressNotification(name string) error {
+	p.mu.RLock()
+	serviceLister := p.serviceLister
+	p.mu.RUnlock()
+
+	if serviceLister == nil {
+		klog.V(3).Infof("Service informer not set yet, ignoring notification for ingress %s", name)
+		return nil
+	}
+
+	services, err := serviceLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	// Shared ingresses are used by several services
+	found := false
+	for _, service := range services {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		// The cluster name passed by the service controller is not known here, match on the service hash
+		if !isLoadBalancerNameOf(name, service, p.clusterName) {
+			continue
+		}
+		found = true
+		klog.V(2).Infof("Requeueing service %s/%s after change notification for ingress %s", service.Namespace, service.Name, name)
+		p.requeueService(service)
+	}
+
+	if !found {
+		klog.V(3).Infof("No service found for ingress %s", name)
+	}
+	return nil
+}
+
+// requeueNode adds the node to the queues of the node controllers
+func (p *VCloudProvider) requeueNode(node *v1.Node) {
+	p.mu.RLock()
+	requeuers := p.nodeRequeuers
+	p.mu.RUnlock()
+
+	for _, requeue := range requeuers {
+		requeue(node)
+	}
+}
+
+// requeueService adds the service to the queue of the service controller
+func (p *VCloudProvider) requeueService(service *v1.Service) {
+	p.mu.RLock()
+	requeuers := p.serviceRequeuers
+	p.mu.RUnlock()
+
+	for _, requeue := range requeuers {
+		requeue(service)
+	}
+}
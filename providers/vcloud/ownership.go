@@ -0,0 +1,102 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Ownership tags set on every ingress created or updated by the provider
+const (
+	TagClusterID        = "k8s.io.infra.vnetwork.dev/cluster-id"
+	TagServiceNamespace = "k8s.io.infra.vnetwork.dev/service-namespace"
+	TagServiceName      = "k8s.io.infra.vnetwork.dev/service-name"
+	TagServiceUID       = "k8s.io.infra.vnetwork.dev/service-uid"
+
+	// TagSharingKey replaces the service name and UID on ingresses shared by the services of a namespace
+	TagSharingKey = "k8s.io.infra.vnetwork.dev/sharing-key"
+
+	// TagRetained replaces the service tags on ingresses released by the Retain policy, so the garbage
+	// collector keeps them
+	TagRetained = "k8s.io.infra.vnetwork.dev/retained"
+)
+
+// ownershipTags returns the tags tying an ingress to the service, or the services sharing it, and the cluster
+func (lb *VCloudLoadBalancer) ownershipTags(service *v1.Service) map[string]string {
+	if sharingKey := getSharingKey(service); sharingKey != "" {
+		return map[string]string{
+			TagClusterID:        lb.provider.clusterID,
+			TagServiceNamespace: service.Namespace,
+			TagSharingKey:       sharingKey,
+		}
+	}
+	return map[string]string{
+		TagClusterID:        lb.provider.clusterID,
+		TagServiceNamespace: service.Namespace,
+		TagServiceName:      service.Name,
+		TagServiceUID:       string(service.UID),
+	}
+}
+
+// isRetained checks if the tags mark an ingress released by the Retain policy and not taken over since
+func isRetained(tags map[string]string) bool {
+	return tags[TagRetained] != "" && tags[TagServiceUID] == "" && tags[TagSharingKey] == ""
+}
+
+// ingressTags returns the tags of an ingress, nil if it has none
+func ingressTags(lbResp *LoadBalancerResponse) map[string]string {
+	if lbResp == nil || lbResp.Data.Config == nil {
+		return nil
+	}
+	return lbResp.Data.Config.Tags
+}
+
+// ownerConflict describes who owns an ingress if its tags name another cluster or, unless service is nil,
+// other services. Untagged ingresses, created before tagging or outside the provider, never conflict.
+func (lb *VCloudLoadBalancer) ownerConflict(tags map[string]string, service *v1.Service) string {
+	if cluster := tags[TagClusterID]; cluster != "" && cluster != lb.provider.clusterID {
+		return fmt.Sprintf("cluster %s", cluster)
+	}
+	if service == nil {
+		return ""
+	}
+
+	if sharingKey := tags[TagSharingKey]; sharingKey != "" {
+		if sharingKey != getSharingKey(service) || tags[TagServiceNamespace] != service.Namespace {
+			return fmt.Sprintf("services sharing key %s in namespace %s", sharingKey, tags[TagServiceNamespace])
+		}
+		return ""
+	}
+	if owner := tags[TagServiceUID]; owner != "" && owner != string(service.UID) {
+		return fmt.Sprintf("service %s/%s (UID %s)", tags[TagServiceNamespace], tags[TagServiceName], owner)
+	}
+	return ""
+}
+
+// checkOwnership refuses to modify an ingress whose tags say it belongs to another service or cluster
+func (lb *VCloudLoadBalancer) checkOwnership(service *v1.Service, name string, lbResp *LoadBalancerResponse) error {
+	owner := lb.ownerConflict(ingressTags(lbResp), service)
+	if owner == "" {
+		return nil
+	}
+
+	lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerOwnershipConflict,
+		"Load balancer %s belongs to %s, refusing to modify it", name, owner)
+	return fmt.Errorf("load balancer %s of service %s/%s belongs to %s", name, service.Namespace, service.Name, owner)
+}
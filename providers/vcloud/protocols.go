@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider/api"
+)
+
+// protocolCapability describes what the mgmt API supports on the ports of a protocol
+type protocolCapability struct {
+	// AppProtocols are the supported appProtocol values besides none
+	AppProtocols []string
+	// ProxyProtocol is set if the PROXY protocol can be sent to the backends
+	ProxyProtocol bool
+}
+
+// protocolCapabilities are the protocols supported by vcloud load balancers. TCP and UDP ports can be
+// mixed in one Service, SCTP is not supported.
+var protocolCapabilities = map[v1.Protocol]protocolCapability{
+	v1.ProtocolTCP: {
+		AppProtocols:  []string{"http", "https", "kubernetes.io/h2c", "kubernetes.io/ws", "kubernetes.io/wss"},
+		ProxyProtocol: true,
+	},
+	v1.ProtocolUDP: {},
+}
+
+// portLabel returns the name of the port, or its number if unnamed
+func portLabel(port v1.ServicePort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return fmt.Sprintf("%d", port.Port)
+}
+
+// unsupportedPorts checks the ports of the service against protocolCapabilities and returns the problems
+func unsupportedPorts(service *v1.Service) []string {
+	p := &annotationParser{annotations: service.Annotations}
+	proxyProtocol := p.parseBool(ServiceAnnotationLoadBalancerProxyProtocol)
+
+	var problems []string
+	for _, port := range service.Spec.Ports {
+		capability, ok := protocolCapabilities[port.Protocol]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("port %s uses protocol %s, only TCP and UDP are supported", portLabel(port), port.Protocol))
+			continue
+		}
+
+		if port.AppProtocol != nil && *port.AppProtocol != "" && !containsFold(capability.AppProtocols, *port.AppProtocol) {
+			if len(capability.AppProtocols) == 0 {
+				problems = append(problems, fmt.Sprintf("port %s has appProtocol %q, %s ports support no appProtocol", portLabel(port), *port.AppProtocol, port.Protocol))
+			} else {
+				problems = append(problems, fmt.Sprintf("port %s has appProtocol %q, %s ports support %s", portLabel(port), *port.AppProtocol, port.Protocol, strings.Join(capability.AppProtocols, ", ")))
+			}
+		}
+		if proxyProtocol && !capability.ProxyProtocol {
+			problems = append(problems, fmt.Sprintf("port %s uses %s, %s only supports TCP ports", portLabel(port), port.Protocol, ServiceAnnotationLoadBalancerProxyProtocol))
+		}
+
+		// The backends are reached on the node ports
+		if port.NodePort < 1 || port.NodePort > 65535 {
+			problems = append(problems, fmt.Sprintf("port %s has no node port, spec.allocateLoadBalancerNodePorts=false is not supported", portLabel(port)))
+		}
+	}
+	return problems
+}
+
+// validatePorts records an UnsupportedProtocol event and returns a NonRetryableError if the ports of the
+// service cannot be served, so they fail before reaching the mgmt API and are not retried until changed
+func (lb *VCloudLoadBalancer) validatePorts(service *v1.Service) error {
+	problems := unsupportedPorts(service)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	message := strings.Join(problems, "; ")
+	lb.provider.eventf(service, v1.EventTypeWarning, eventReasonUnsupportedProtocol, "Unsupported load balancer ports: %s", message)
+	return api.NewNonRetryableError(fmt.Sprintf("service %s/%s has unsupported load balancer ports: %s", service.Namespace, service.Name, message))
+}
+
+// containsFold checks if the values contain s, ignoring case
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider/api"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// provisioningRetryDelay is how long the service controller waits before checking a provisioning
+	// load balancer again, unless the mgmt API suggests a delay with Retry-After
+	provisioningRetryDelay = 10 * time.Second
+
+	// maxProvisioningRetryDelay caps the delay suggested by the mgmt API
+	maxProvisioningRetryDelay = 5 * time.Minute
+
+	// provisioningStuckThreshold is how long a load balancer may provision before a warning is recorded
+	provisioningStuckThreshold = 5 * time.Minute
+)
+
+// Ingress states reported by the mgmt API
+const (
+	IngressStatePending      = "PENDING"
+	IngressStateProvisioning = "PROVISIONING"
+	IngressStateActive       = "ACTIVE"
+	IngressStateFailed       = "FAILED"
+)
+
+// provisioningState tracks a load balancer waiting for its ingress address
+type provisioningState struct {
+	since      time.Time
+	lastWarned time.Time
+}
+
+// checkProvisioned returns a RetryError while the load balancer has no ingress address yet
+// and records an event when provisioning failed or takes too long
+func (lb *VCloudLoadBalancer) checkProvisioned(service *v1.Service, lbName string, header http.Header, lbResp *LoadBalancerResponse) error {
+	state := strings.ToUpper(lbResp.Data.State)
+
+	if state == IngressStateFailed {
+		lb.clearProvisioning(lbName)
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerProvisioningFailed,
+			"Provisioning of load balancer %s failed: %s", lbName, lbResp.Data.Message)
+		return fmt.Errorf("provisioning of load balancer %s failed: %s", lbName, lbResp.Data.Message)
+	}
+
+	if !isIngressPending(state, lbResp) {
+		lb.clearProvisioning(lbName)
+		return nil
+	}
+
+	if state == "" {
+		state = IngressStatePending
+	}
+	since := lb.trackProvisioning(service, lbName, state)
+
+	delay := retryAfter(header)
+	klog.V(2).Infof("Load balancer %s is %s for %v, checking again in %v", lbName, state, time.Since(since).Round(time.Second), delay)
+	return api.NewRetryError(fmt.Sprintf("load balancer %s is %s, waiting for an ingress address", lbName, state), delay)
+}
+
+// isIngressPending checks if the ingress is still being provisioned or has no address yet
+func isIngressPending(state string, lbResp *LoadBalancerResponse) bool {
+	if state == IngressStatePending || state == IngressStateProvisioning {
+		return true
+	}
+	for _, ingress := range lbResp.Data.Ingress {
+		if ingress.IP != "" || ingress.Hostname != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// trackProvisioning records when the load balancer started provisioning and warns when it is stuck
+func (lb *VCloudLoadBalancer) trackProvisioning(service *v1.Service, lbName, state string) time.Time {
+	now := time.Now()
+
+	lb.mu.Lock()
+	if lb.provisioning == nil {
+		lb.provisioning = make(map[string]*provisioningState)
+	}
+	entry, ok := lb.provisioning[lbName]
+	if !ok {
+		entry = &provisioningState{since: now}
+		lb.provisioning[lbName] = entry
+	}
+	warn := now.Sub(entry.since) >= provisioningStuckThreshold && now.Sub(entry.lastWarned) >= provisioningStuckThreshold
+	if warn {
+		entry.lastWarned = now
+	}
+	since := entry.since
+	lb.mu.Unlock()
+
+	if warn {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerProvisioningStuck,
+			"Load balancer %s has been %s for %v without an ingress address", lbName, state, now.Sub(since).Round(time.Second))
+	}
+	return since
+}
+
+// clearProvisioning forgets a load balancer that is provisioned, failed or deleted
+func (lb *VCloudLoadBalancer) clearProvisioning(lbName string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	delete(lb.provisioning, lbName)
+}
+
+// retryAfter returns the delay suggested by the Retry-After header in seconds, or the default delay
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header.Get("Retry-After")))
+	if err != nil || seconds <= 0 {
+		return provisioningRetryDelay
+	}
+	if delay := time.Duration(seconds) * time.Second; delay < maxProvisioningRetryDelay {
+		return delay
+	}
+	return maxProvisioningRetryDelay
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// PublicIP represents a public IP of the tenant pool
+type PublicIP struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Reserved bool   `json:"reserved"`
+	// Ingress is the name of the ingress the IP is bound to, empty if the IP is free
+	Ingress string `json:"ingress"`
+}
+
+// getPublicIP looks up a public IP of the tenant pool by address or reserved name, nil if it does not exist
+func (lb *VCloudLoadBalancer) getPublicIP(ctx context.Context, key string) (*PublicIP, error) {
+	path := fmt.Sprintf("/public-ips/%s", url.PathEscape(key))
+	resp, err := lb.provider.Request(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public IP %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+
+	// Handle 404 - not in the tenant pool
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var apiResp struct {
+		Status int `json:"status"`
+		Data   struct {
+			PublicIP PublicIP `json:"publicIP"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &apiResp.Data.PublicIP, nil
+}
+
+// bindPublicIP verifies that the requested or reserved IP of the request is owned by the tenant and
+// free or already bound to this load balancer, and pins its address in the request
+func (lb *VCloudLoadBalancer) bindPublicIP(ctx context.Context, service *v1.Service, req *LoadBalancerRequest) error {
+	key := req.LoadBalancerIP
+	if req.ReservedIP != "" {
+		key = req.ReservedIP
+	}
+	if key == "" {
+		return nil
+	}
+
+	publicIP, err := lb.getPublicIP(ctx, key)
+	if err != nil {
+		lb.provider.recordAPIError(service, "Looking up public IP "+key, err)
+		return err
+	}
+
+	if publicIP == nil || (req.ReservedIP != "" && !publicIP.Reserved) {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerIPNotReserved,
+			"Public IP %s is not reserved in the tenant pool", key)
+		return fmt.Errorf("public IP %s for service %s/%s is not reserved in the tenant pool", key, service.Namespace, service.Name)
+	}
+
+	// The IP of a load balancer still named after the legacy scheme moves along when it is renamed
+	if publicIP.Ingress != "" && publicIP.Ingress != req.Name && publicIP.Ingress != legacyLoadBalancerName(lb.provider.clusterName, service) {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerIPUnavailable,
+			"Public IP %s is already used by ingress %s", key, publicIP.Ingress)
+		return fmt.Errorf("public IP %s for service %s/%s is already used by ingress %s", key, service.Namespace, service.Name, publicIP.Ingress)
+	}
+
+	klog.V(3).Infof("Binding public IP %s (%s) to load balancer %s", publicIP.Address, key, req.Name)
+	req.LoadBalancerIP = publicIP.Address
+	return nil
+}
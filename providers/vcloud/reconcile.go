@@ -0,0 +1,241 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Outcomes of reconciling a load balancer
+const (
+	reconcileCreated   = "created"
+	reconcileUpdated   = "updated"
+	reconcileUnchanged = "unchanged"
+	reconcileRecreated = "recreated"
+)
+
+// fieldChange is a top-level field of the load balancer request that differs from the current state
+type fieldChange struct {
+	Field string
+	Old   json.RawMessage
+	New   json.RawMessage
+}
+
+// loadBalancerDiff lists the changed fields of a load balancer, ordered by field name
+type loadBalancerDiff []fieldChange
+
+// String returns the names of the changed fields
+func (d loadBalancerDiff) String() string {
+	fields := make([]string, 0, len(d))
+	for _, change := range d {
+		fields = append(fields, change.Field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// patch builds a JSON merge patch setting the changed fields, removed fields are set to null
+func (d loadBalancerDiff) patch() map[string]json.RawMessage {
+	patch := make(map[string]json.RawMessage, len(d))
+	for _, change := range d {
+		if change.New == nil {
+			patch[change.Field] = json.RawMessage("null")
+			continue
+		}
+		patch[change.Field] = change.New
+	}
+	return patch
+}
+
+// diffLoadBalancerRequest compares the current configuration of a load balancer with the desired one.
+// Both are compared in their JSON form, so omitted and empty fields are treated alike.
+func diffLoadBalancerRequest(current, desired *LoadBalancerRequest) (loadBalancerDiff, error) {
+	currentFields, err := requestFields(current)
+	if err != nil {
+		return nil, err
+	}
+	desiredFields, err := requestFields(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for key := range currentFields {
+		keys[key] = true
+	}
+	for key := range desiredFields {
+		keys[key] = true
+	}
+
+	var diff loadBalancerDiff
+	for key := range keys {
+		if bytes.Equal(currentFields[key], desiredFields[key]) {
+			continue
+		}
+		diff = append(diff, fieldChange{Field: key, Old: currentFields[key], New: desiredFields[key]})
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Field < diff[j].Field
+	})
+	return diff, nil
+}
+
+// requestFields returns the non-empty top-level JSON fields of a load balancer request
+func requestFields(req *LoadBalancerRequest) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if req == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request: %v", err)
+	}
+
+	for key, value := range fields {
+		switch string(value) {
+		case "null", "[]", "{}":
+			delete(fields, key)
+		}
+	}
+	return fields, nil
+}
+
+// reconcileIngress creates the ingress if it does not exist, patches the fields that differ from the
+// desired request, or leaves it alone, and returns the resulting ingress with the response headers and
+// whether the ingress terminated TLS before.
+// An ingress found under its legacy name is renamed first, or adopted by setting the request name to it.
+// An ingress moving to another frontend network is recreated.
+func (lb *VCloudLoadBalancer) reconcileIngress(ctx context.Context, service *v1.Service, req *LoadBalancerRequest) (*LoadBalancerResponse, http.Header, bool, error) {
+	current, header, name, err := lb.lookupIngress(ctx, service, req.Name)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err := lb.checkOwnership(service, name, current); err != nil {
+		return nil, nil, false, err
+	}
+	if err := lb.checkAdoption(service, name, current); err != nil {
+		return nil, nil, false, err
+	}
+
+	if current != nil && name != req.Name {
+		renamed, renamedHeader, migratedName, err := lb.migrateIngress(ctx, service, name, req.Name)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if renamed != nil {
+			current, header = renamed, renamedHeader
+		}
+		req.Name = migratedName
+	}
+	path := fmt.Sprintf("/ingresses/%s", req.Name)
+	servedTLS := current != nil && hasTLSPorts(current.Data.Config)
+
+	if current == nil {
+		created, header, err := lb.ingressRequest(ctx, service, "POST", "/ingresses", "Ensuring load balancer "+req.Name, req)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if created == nil {
+			return nil, nil, false, fmt.Errorf("failed to create load balancer %s: not found", req.Name)
+		}
+		loadBalancerReconciles.WithLabelValues(reconcileCreated).Inc()
+		klog.V(2).Infof("Load balancer %s %s", req.Name, reconcileCreated)
+		return created, header, servedTLS, nil
+	}
+
+	// The frontend IP cannot move between networks, the ingress is recreated instead
+	if changes := frontendChanges(current.Data.Config, req); len(changes) > 0 {
+		recreated, header, err := lb.recreateIngress(ctx, service, req, current, changes)
+		return recreated, header, servedTLS, err
+	}
+	keepFrontend(current.Data.Config, req)
+
+	diff, err := diffLoadBalancerRequest(current.Data.Config, req)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(diff) == 0 {
+		loadBalancerReconciles.WithLabelValues(reconcileUnchanged).Inc()
+		klog.V(4).Infof("Load balancer %s %s", req.Name, reconcileUnchanged)
+		return current, header, servedTLS, nil
+	}
+
+	for _, change := range diff {
+		klog.V(4).Infof("Load balancer %s field %s: %s -> %s", req.Name, change.Field, change.Old, change.New)
+	}
+	updated, header, err := lb.ingressRequest(ctx, service, "PATCH", path, "Ensuring load balancer "+req.Name, diff.patch())
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if updated == nil {
+		return nil, nil, false, fmt.Errorf("failed to update load balancer %s: not found", req.Name)
+	}
+	loadBalancerReconciles.WithLabelValues(reconcileUpdated).Inc()
+	klog.V(2).Infof("Load balancer %s %s (%s)", req.Name, reconcileUpdated, diff)
+	return updated, header, servedTLS, nil
+}
+
+// ingressRequest sends a request for an ingress and decodes the response, nil if the ingress does not exist.
+// API errors are recorded on the service unless it is nil.
+func (lb *VCloudLoadBalancer) ingressRequest(ctx context.Context, service *v1.Service, method, path, operation string, payload interface{}) (*LoadBalancerResponse, http.Header, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := lb.provider.Request(ctx, method, path, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	// Handle 404 - load balancer doesn't exist
+	if resp.StatusCode == 404 {
+		return nil, resp.Header, nil
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		apiErr := newAPIError(resp)
+		if service != nil {
+			lb.provider.recordAPIError(service, operation, apiErr)
+		}
+		return nil, nil, apiErr
+	}
+
+	var lbResp LoadBalancerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lbResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &lbResp, resp.Header, nil
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// sharingLock serializes the operations on one shared load balancer, it is dropped once no
+// service holds or waits for it
+type sharingLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockSharing serializes the operations on the load balancer of a service sharing it with other
+// services, and returns the function releasing the lock. Services with other sharing keys are not blocked.
+func (lb *VCloudLoadBalancer) lockSharing(service *v1.Service) func() {
+	sharingKey := getSharingKey(service)
+	if sharingKey == "" {
+		return func() {}
+	}
+	key := service.Namespace + "/" + sharingKey
+
+	lb.sharingMu.Lock()
+	if lb.sharing == nil {
+		lb.sharing = make(map[string]*sharingLock)
+	}
+	lock, ok := lb.sharing[key]
+	if !ok {
+		lock = &sharingLock{}
+		lb.sharing[key] = lock
+	}
+	lock.refs++
+	lb.sharingMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		lb.sharingMu.Lock()
+		defer lb.sharingMu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(lb.sharing, key)
+		}
+	}
+}
+
+// sharingMembers returns the LoadBalancer services of the namespace with the sharing key, oldest first.
+// The given service is replaced by its current version, or left out if exclude is set.
+func (lb *VCloudLoadBalancer) sharingMembers(service *v1.Service, sharingKey string, exclude bool) ([]*v1.Service, error) {
+	lb.provider.mu.RLock()
+	serviceLister := lb.provider.serviceLister
+	lb.provider.mu.RUnlock()
+
+	// Without the informer the ports of the other members are unknown and would be removed
+	if serviceLister == nil {
+		return nil, fmt.Errorf("service informer not set yet, cannot share load balancer %s", sharingKey)
+	}
+
+	services, err := serviceLister.Services(service.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+
+	var members []*v1.Service
+	for _, member := range services {
+		if member.UID == service.UID || member.DeletionTimestamp != nil || member.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		if getSharingKey(member) == sharingKey {
+			members = append(members, member)
+		}
+	}
+	if !exclude {
+		members = append(members, service)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if !members[i].CreationTimestamp.Equal(&members[j].CreationTimestamp) {
+			return members[i].CreationTimestamp.Before(&members[j].CreationTimestamp)
+		}
+		return members[i].Name < members[j].Name
+	})
+	return members, nil
+}
+
+// mergeSharedPorts merges the ports of the members, prefixing their names with the service name. A member
+// whose ports overlap with an older member is left out, which fails the sync if it is the given service.
+func (lb *VCloudLoadBalancer) mergeSharedPorts(ctx context.Context, service *v1.Service, members []*v1.Service) ([]LoadBalancerPort, error) {
+	owners := make(map[string]string)
+	var ports []LoadBalancerPort
+	for _, member := range members {
+		// Unsupported ports of another member would fail the whole load balancer
+		if problems := unsupportedPorts(member); len(problems) > 0 && member.UID != service.UID {
+			klog.V(2).Infof("Leaving service %s/%s out of its shared load balancer: %s", member.Namespace, member.Name, strings.Join(problems, "; "))
+			continue
+		}
+		memberPorts, err := lb.buildServicePorts(ctx, member)
+		if err != nil {
+			return nil, err
+		}
+
+		conflict := ""
+		for _, port := range memberPorts {
+			if owner, ok := owners[fmt.Sprintf("%d/%s", port.Port, port.Protocol)]; ok {
+				conflict = fmt.Sprintf("port %d/%s is already used by service %s", port.Port, port.Protocol, owner)
+				break
+			}
+		}
+		if conflict != "" {
+			if member.UID == service.UID {
+				lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerSharingConflict,
+					"Cannot share the load balancer of key %s: %s", getSharingKey(service), conflict)
+				return nil, fmt.Errorf("service %s/%s cannot share its load balancer: %s", service.Namespace, service.Name, conflict)
+			}
+			klog.V(2).Infof("Leaving service %s/%s out of its shared load balancer: %s", member.Namespace, member.Name, conflict)
+			continue
+		}
+
+		for _, port := range memberPorts {
+			owners[fmt.Sprintf("%d/%s", port.Port, port.Protocol)] = member.Name
+			port.Name = member.Name + "-" + port.Name
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// sharedSettingsConflict returns the fields of the request of a member, other than its ports, that differ
+// from the request of the oldest member
+func sharedSettingsConflict(oldest, member *LoadBalancerRequest) ([]string, error) {
+	reference, settings := *oldest, *member
+	reference.Ports, settings.Ports = nil, nil
+	diff, err := diffLoadBalancerRequest(&reference, &settings)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(diff))
+	for _, change := range diff {
+		fields = append(fields, change.Field)
+	}
+	return fields, nil
+}
+
+// compatibleMembers returns the members whose settings match the oldest one, with the request of the
+// oldest one. A member with other settings (source ranges, health check, session affinity, annotations...)
+// is left out, which fails the sync if it is the given service.
+func (lb *VCloudLoadBalancer) compatibleMembers(name string, service *v1.Service, members []*v1.Service, nodes []*v1.Node) ([]*v1.Service, *LoadBalancerRequest, error) {
+	var oldest *LoadBalancerRequest
+	var compatible []*v1.Service
+	for _, member := range members {
+		req, err := lb.buildLoadBalancerRequest(name, member, nodes)
+		if err != nil {
+			if member.UID == service.UID {
+				return nil, nil, err
+			}
+			klog.V(2).Infof("Leaving service %s/%s out of its shared load balancer: %v", member.Namespace, member.Name, err)
+			continue
+		}
+		if oldest == nil {
+			oldest = req
+			compatible = append(compatible, member)
+			continue
+		}
+
+		fields, err := sharedSettingsConflict(oldest, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fields) > 0 {
+			conflict := fmt.Sprintf("its %s differ from the older service %s", strings.Join(fields, ", "), compatible[0].Name)
+			if member.UID == service.UID {
+				lb.provider.eventf(service, v1.EventTypeWarning, eventReasonLoadBalancerSharingConflict,
+					"Cannot share the load balancer of key %s: %s", getSharingKey(service), conflict)
+				return nil, nil, fmt.Errorf("service %s/%s cannot share its load balancer: %s", service.Namespace, service.Name, conflict)
+			}
+			klog.V(2).Infof("Leaving service %s/%s out of its shared load balancer: %s", member.Namespace, member.Name, conflict)
+			continue
+		}
+		compatible = append(compatible, member)
+	}
+	return compatible, oldest, nil
+}
+
+// buildRequest builds the load balancer request of the service. The request of a shared load balancer
+// is built from its oldest member, with the ports of all members sharing its settings. Unsupported ports
+// fail with a NonRetryableError.
+func (lb *VCloudLoadBalancer) buildRequest(ctx context.Context, name string, service *v1.Service, nodes []*v1.Node) (*LoadBalancerRequest, error) {
+	if err := lb.validatePorts(service); err != nil {
+		return nil, err
+	}
+
+	sharingKey := getSharingKey(service)
+	if sharingKey == "" {
+		req, err := lb.buildLoadBalancerRequest(name, service, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if req.Ports, err = lb.buildServicePorts(ctx, service); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	members, err := lb.sharingMembers(service, sharingKey, false)
+	if err != nil {
+		return nil, err
+	}
+	members, req, err := lb.compatibleMembers(name, service, members, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if req.Ports, err = lb.mergeSharedPorts(ctx, service, members); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// releaseSharedIngress removes the ports of the service from its shared load balancer. It returns false
+// if no other member uses the load balancer, which is then deleted according to the retain policy.
+func (lb *VCloudLoadBalancer) releaseSharedIngress(ctx context.Context, service *v1.Service, name string) (bool, error) {
+	sharingKey := getSharingKey(service)
+	if sharingKey == "" {
+		return false, nil
+	}
+
+	members, err := lb.sharingMembers(service, sharingKey, true)
+	if err != nil {
+		return false, err
+	}
+	if len(members) == 0 {
+		klog.V(2).Infof("Service %s/%s is the last user of load balancer %s", service.Namespace, service.Name, name)
+		return false, nil
+	}
+	// The backends are left alone, only the members sharing the settings of the ingress keep their ports
+	if members, _, err = lb.compatibleMembers(name, service, members, nil); err != nil {
+		return false, err
+	}
+	ports, err := lb.mergeSharedPorts(ctx, service, members)
+	if err != nil {
+		return false, err
+	}
+
+	path := fmt.Sprintf("/ingresses/%s", name)
+	current, _, err := lb.ingressRequest(ctx, service, "GET", path, "Getting load balancer "+name, nil)
+	if err != nil {
+		return false, err
+	}
+	if current == nil {
+		return true, nil
+	}
+	if err := lb.checkOwnership(service, name, current); err != nil {
+		return false, err
+	}
+
+	if _, _, err := lb.ingressRequest(ctx, service, "PATCH", path, "Updating load balancer "+name, map[string]interface{}{"ports": ports}); err != nil {
+		return false, err
+	}
+	klog.V(2).Infof("Removed the ports of service %s/%s from load balancer %s, still used by %d services",
+		service.Namespace, service.Name, name, len(members))
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonSharedLoadBalancerKept,
+		"Removed the ports of the service from load balancer %s, still used by %d services", name, len(members))
+	return true, nil
+}
+
+// filterSharedPortStatus keeps the port status of the service's own ports on a shared load balancer
+func filterSharedPortStatus(service *v1.Service, status *v1.LoadBalancerStatus) {
+	if getSharingKey(service) == "" {
+		return
+	}
+
+	own := make(map[string]bool)
+	for _, port := range service.Spec.Ports {
+		own[fmt.Sprintf("%d/%s", port.Port, port.Protocol)] = true
+	}
+	for i := range status.Ingress {
+		var ports []v1.PortStatus
+		for _, port := range status.Ingress[i].Ports {
+			if own[fmt.Sprintf("%d/%s", port.Port, port.Protocol)] {
+				ports = append(ports, port)
+			}
+		}
+		status.Ingress[i].Ports = ports
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// portErrorDomain qualifies port errors reported by the mgmt API, as required for cloud provider errors
+	portErrorDomain = "k8s.io.infra.vnetwork.dev"
+
+	// portErrorUnknown replaces port errors that are not valid qualified names
+	portErrorUnknown = portErrorDomain + "/PortError"
+)
+
+// buildLoadBalancerStatus publishes all ingresses of the response, ordered by the service's IP families.
+// Shared load balancers only report the status of the service's own ports.
+func buildLoadBalancerStatus(service *v1.Service, lbResp *LoadBalancerResponse) *v1.LoadBalancerStatus {
+	families := getServiceIPFamilies(service)
+	rank := func(ingress LoadBalancerIngress) int {
+		ipFamily := v1.IPFamily(ingress.IPFamily)
+		if ipFamily == "" {
+			ipFamily = ipFamilyOf(ingress.IP)
+		}
+		for i, f := range families {
+			if f == ipFamily {
+				return i
+			}
+		}
+		return len(families)
+	}
+
+	ingresses := append(lbResp.Data.Ingress[:0:0], lbResp.Data.Ingress...)
+	sort.SliceStable(ingresses, func(i, j int) bool {
+		return rank(ingresses[i]) < rank(ingresses[j])
+	})
+
+	status := &v1.LoadBalancerStatus{}
+	seen := make(map[string]bool)
+	for _, ingress := range ingresses {
+		key := ingress.IP + "/" + ingress.Hostname
+		if (ingress.IP == "" && ingress.Hostname == "") || seen[key] {
+			continue
+		}
+		seen[key] = true
+		status.Ingress = append(status.Ingress, buildLoadBalancerIngress(ingress))
+	}
+	filterSharedPortStatus(service, status)
+	return status
+}
+
+// buildLoadBalancerIngress maps an ingress of the mgmt API to the Service status
+func buildLoadBalancerIngress(ingress LoadBalancerIngress) v1.LoadBalancerIngress {
+	result := v1.LoadBalancerIngress{
+		IP:       ingress.IP,
+		Hostname: ingress.Hostname,
+	}
+
+	// ipMode may only be set together with an IP
+	if ingress.IP != "" && ingress.IPMode != "" {
+		switch {
+		case strings.EqualFold(ingress.IPMode, string(v1.LoadBalancerIPModeVIP)):
+			ipMode := v1.LoadBalancerIPModeVIP
+			result.IPMode = &ipMode
+		case strings.EqualFold(ingress.IPMode, string(v1.LoadBalancerIPModeProxy)):
+			ipMode := v1.LoadBalancerIPModeProxy
+			result.IPMode = &ipMode
+		default:
+			klog.Warningf("Ignoring unknown ipMode %q of load balancer ingress %s", ingress.IPMode, ingress.IP)
+		}
+	}
+
+	for _, port := range ingress.Ports {
+		portStatus := v1.PortStatus{
+			Port:     port.Port,
+			Protocol: v1.Protocol(strings.ToUpper(port.Protocol)),
+		}
+		if port.Error != "" {
+			portError := qualifyPortError(port.Error)
+			portStatus.Error = &portError
+		}
+		result.Ports = append(result.Ports, portStatus)
+	}
+
+	return result
+}
+
+// qualifyPortError turns a port error of the mgmt API into a qualified CamelCase name,
+// the format the API server accepts for cloud provider specific port errors
+func qualifyPortError(portError string) string {
+	if !strings.Contains(portError, "/") {
+		portError = portErrorDomain + "/" + portError
+	}
+	if errs := validation.IsQualifiedName(portError); len(errs) > 0 {
+		klog.V(2).Infof("Reporting invalid port error %q as %s: %s", portError, portErrorUnknown, strings.Join(errs, ", "))
+		return portErrorUnknown
+	}
+	return portError
+}
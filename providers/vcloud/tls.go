@@ -0,0 +1,553 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// vcloudCertificatePrefix marks the entries of the tls-certificates annotation that are vcloud certificate IDs
+	vcloudCertificatePrefix = "vcloud:"
+
+	// TagSecretName is the Secret a certificate was uploaded from, in the namespace of TagServiceNamespace
+	TagSecretName = "k8s.io.infra.vnetwork.dev/secret-name"
+)
+
+// Backend protocols of a TLS port, selected by the appProtocol of the Service port
+const (
+	// BackendProtocolTCP forwards the decrypted stream as is
+	BackendProtocolTCP = "tcp"
+	// BackendProtocolHTTP forwards plain HTTP
+	BackendProtocolHTTP = "http"
+	// BackendProtocolHTTPS encrypts the traffic again towards the backends
+	BackendProtocolHTTPS = "https"
+)
+
+// certificateRef references a kubernetes.io/tls Secret of the Service namespace or a vcloud certificate
+type certificateRef struct {
+	Secret string
+	ID     string
+}
+
+// tlsOptions holds the certificates of a Service and the names or numbers of its ports terminating TLS,
+// all TCP ports if Ports is empty
+type tlsOptions struct {
+	Certificates []certificateRef
+	Ports        []string
+}
+
+// LoadBalancerPortTLS is the TLS termination of a load balancer port
+type LoadBalancerPortTLS struct {
+	// Certificates are the IDs of the vcloud certificates served on the port, the first one is the default
+	Certificates    []string `json:"certificates"`
+	BackendProtocol string   `json:"backendProtocol"`
+}
+
+// Certificate is a TLS certificate stored by the mgmt API. Certificate and PrivateKey are only sent on
+// upload, Fingerprint is the SHA-256 of the DER encoded leaf certificate.
+type Certificate struct {
+	ID          string            `json:"id,omitempty"`
+	Name        string            `json:"name"`
+	Certificate string            `json:"certificate,omitempty"`
+	PrivateKey  string            `json:"privateKey,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// CertificateResponse represents the API response for certificate operations
+type CertificateResponse struct {
+	Status int         `json:"status"`
+	Data   Certificate `json:"data"`
+}
+
+// buildCertificateName returns {cluster}-cert-{namespace}-{secret}-{hash} for the certificate uploaded from
+// a Secret, with the hash of the namespace and Secret name
+func buildCertificateName(clusterName, namespace, secretName string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + secretName))
+	return composeName(namePrefix(clusterName, "cert"), namespace+"-"+secretName, hex.EncodeToString(sum[:])[:loadBalancerNameHashLength])
+}
+
+// findServicePort returns the port of the service with the given name or number, nil if there is none
+func findServicePort(service *v1.Service, port string) *v1.ServicePort {
+	for i := range service.Spec.Ports {
+		svcPort := &service.Spec.Ports[i]
+		if svcPort.Name == port || strconv.Itoa(int(svcPort.Port)) == port {
+			return svcPort
+		}
+	}
+	return nil
+}
+
+// tlsBackendProtocol selects the backend protocol of a TLS port from its appProtocol
+func tlsBackendProtocol(appProtocol string) string {
+	switch strings.ToLower(appProtocol) {
+	case "https", "kubernetes.io/wss":
+		return BackendProtocolHTTPS
+	case "http", "kubernetes.io/h2c", "kubernetes.io/ws":
+		return BackendProtocolHTTP
+	default:
+		return BackendProtocolTCP
+	}
+}
+
+// buildServicePorts builds the load balancer ports of the service and sets up TLS termination on the
+// selected ports, uploading the certificates of its Secrets
+func (lb *VCloudLoadBalancer) buildServicePorts(ctx context.Context, service *v1.Service) ([]LoadBalancerPort, error) {
+	ports := buildPorts(service)
+
+	options, err := getTLSOptions(service)
+	if err != nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidAnnotation, "Invalid load balancer annotations: %v", err)
+		return nil, fmt.Errorf("invalid annotations on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	if options == nil {
+		return ports, nil
+	}
+
+	certificates, err := lb.ensureCertificates(ctx, service, options.Certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool, len(options.Ports))
+	for _, port := range options.Ports {
+		if svcPort := findServicePort(service, port); svcPort != nil {
+			selected[strconv.Itoa(int(svcPort.Port))] = true
+		}
+	}
+	for i := range ports {
+		if ports[i].Protocol != string(v1.ProtocolTCP) || (len(selected) > 0 && !selected[strconv.Itoa(int(ports[i].Port))]) {
+			continue
+		}
+		ports[i].TLS = &LoadBalancerPortTLS{
+			Certificates:    certificates,
+			BackendProtocol: tlsBackendProtocol(ports[i].AppProtocol),
+		}
+	}
+	return ports, nil
+}
+
+// ensureCertificates returns the vcloud certificate IDs of the references, in order. The certificates of
+// Secrets are uploaded, or replaced if the Secret holds another certificate.
+func (lb *VCloudLoadBalancer) ensureCertificates(ctx context.Context, service *v1.Service, refs []certificateRef) ([]string, error) {
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref.ID != "" {
+			ids = append(ids, ref.ID)
+			continue
+		}
+		id, err := lb.ensureSecretCertificate(ctx, service, ref.Secret)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ensureSecretCertificate uploads the certificate of a kubernetes.io/tls Secret and returns its ID
+func (lb *VCloudLoadBalancer) ensureSecretCertificate(ctx context.Context, service *v1.Service, secretName string) (string, error) {
+	certPEM, keyPEM, fingerprint, err := lb.readTLSSecret(ctx, service.Namespace, secretName)
+	if err != nil {
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonInvalidTLSCertificate, "Invalid TLS certificate: %v", err)
+		return "", fmt.Errorf("service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	name := buildCertificateName(lb.provider.clusterName, service.Namespace, secretName)
+	path := fmt.Sprintf("/certificates/%s", name)
+	current, err := lb.certificateRequest(ctx, service, "GET", path, "Getting certificate "+name, nil)
+	if err != nil {
+		return "", err
+	}
+	if current != nil {
+		if owner := lb.ownerConflict(current.Tags, nil); owner != "" {
+			return "", fmt.Errorf("certificate %s belongs to %s", name, owner)
+		}
+		if current.Fingerprint == fingerprint {
+			return current.ID, nil
+		}
+	}
+
+	certificate := &Certificate{
+		Name:        name,
+		Certificate: string(certPEM),
+		PrivateKey:  string(keyPEM),
+		Tags: map[string]string{
+			TagClusterID:        lb.provider.clusterID,
+			TagServiceNamespace: service.Namespace,
+			TagSecretName:       secretName,
+		},
+	}
+	if current != nil && current.Tags[TagRetained] != "" {
+		certificate.Tags[TagRetained] = current.Tags[TagRetained]
+	}
+	if current == nil {
+		klog.V(2).Infof("Uploading certificate %s from secret %s/%s", name, service.Namespace, secretName)
+		uploaded, err := lb.certificateRequest(ctx, service, "POST", "/certificates", "Uploading certificate "+name, certificate)
+		if err != nil {
+			return "", err
+		}
+		lb.provider.eventf(service, v1.EventTypeNormal, eventReasonTLSCertificateUploaded,
+			"Uploaded the certificate of secret %s as %s", secretName, uploaded.ID)
+		return uploaded.ID, nil
+	}
+
+	klog.V(2).Infof("Rotating certificate %s, secret %s/%s holds certificate %s instead of %s",
+		name, service.Namespace, secretName, fingerprint, current.Fingerprint)
+	rotated, err := lb.certificateRequest(ctx, service, "PUT", path, "Rotating certificate "+name, certificate)
+	if err != nil {
+		return "", err
+	}
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonTLSCertificateRotated,
+		"Rotated certificate %s to the current certificate of secret %s", rotated.ID, secretName)
+	return rotated.ID, nil
+}
+
+// readTLSSecret returns the certificate chain, the private key and the leaf certificate fingerprint of a
+// kubernetes.io/tls Secret
+func (lb *VCloudLoadBalancer) readTLSSecret(ctx context.Context, namespace, name string) ([]byte, []byte, string, error) {
+	secretLister, err := lb.provider.tlsSecretLister(ctx)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cannot read secret %s: %v", name, err)
+	}
+
+	secret, err := secretLister.Secrets(namespace).Get(name)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to get secret %s: %v", name, err)
+	}
+	if secret.Type != v1.SecretTypeTLS {
+		return nil, nil, "", fmt.Errorf("secret %s has type %s, expected %s", name, secret.Type, v1.SecretTypeTLS)
+	}
+
+	certPEM, keyPEM := secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("secret %s does not hold a valid certificate and key: %v", name, err)
+	}
+	sum := sha256.Sum256(pair.Certificate[0])
+	return certPEM, keyPEM, hex.EncodeToString(sum[:]), nil
+}
+
+// certificateRequest sends a certificate request and decodes the certificate, nil if it does not exist
+func (lb *VCloudLoadBalancer) certificateRequest(ctx context.Context, service *v1.Service, method, path, operation string, payload interface{}) (*Certificate, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := lb.provider.Request(ctx, method, path, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 && (method == "GET" || method == "PATCH") {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		apiErr := newAPIError(resp)
+		lb.provider.recordAPIError(service, operation, apiErr)
+		return nil, apiErr
+	}
+
+	var certResp CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&certResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &certResp.Data, nil
+}
+
+// CertificateListResponse represents the API response listing the certificates
+type CertificateListResponse struct {
+	Status int `json:"status"`
+	Data   struct {
+		Certificates []Certificate `json:"certificates"`
+	} `json:"data"`
+}
+
+// hasTLSPorts checks if a load balancer configuration terminates TLS on any port
+func hasTLSPorts(config *LoadBalancerRequest) bool {
+	if config == nil {
+		return false
+	}
+	for _, port := range config.Ports {
+		if port.TLS != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// referencesTLSSecrets checks if the service serves certificates of kubernetes.io/tls Secrets
+func referencesTLSSecrets(service *v1.Service) bool {
+	options, err := getTLSOptions(service)
+	if err != nil || options == nil {
+		return false
+	}
+	for _, ref := range options.Certificates {
+		if ref.Secret != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupCertificates removes the certificates no longer used after a sync of the service. A failure does
+// not fail the sync, it is reported and the certificates are removed with a later sync of the namespace.
+func (lb *VCloudLoadBalancer) cleanupCertificates(ctx context.Context, service *v1.Service, deleted bool) {
+	if err := lb.removeUnusedCertificates(ctx, service, deleted); err != nil {
+		klog.Errorf("Failed to remove the unused certificates of namespace %s: %v", service.Namespace, err)
+		lb.provider.eventf(service, v1.EventTypeWarning, eventReasonTLSCertificateCleanupFailed,
+			"Failed to delete the certificates no service uses any more: %v", err)
+	}
+}
+
+// removeUnusedCertificates deletes the certificates uploaded from Secrets of the service namespace that no
+// LoadBalancer service references any more, leaving out the service once deleted. Certificates kept for
+// retained load balancers are never deleted.
+func (lb *VCloudLoadBalancer) removeUnusedCertificates(ctx context.Context, service *v1.Service, deleted bool) error {
+	lb.provider.mu.RLock()
+	serviceLister := lb.provider.serviceLister
+	lb.provider.mu.RUnlock()
+
+	if serviceLister == nil {
+		klog.V(3).Infof("Service informer not set yet, not removing the unused certificates of namespace %s", service.Namespace)
+		return nil
+	}
+
+	services, err := serviceLister.Services(service.Namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	referenced := make(map[string]bool)
+	others := make([]*v1.Service, 0, len(services)+1)
+	for _, other := range services {
+		if other.UID != service.UID && other.Spec.Type == v1.ServiceTypeLoadBalancer {
+			others = append(others, other)
+		}
+	}
+	if !deleted {
+		others = append(others, service)
+	}
+	for _, other := range others {
+		options, err := getTLSOptions(other)
+		if err != nil {
+			klog.V(2).Infof("Not removing the unused certificates of namespace %s, service %s has invalid annotations: %v",
+				service.Namespace, other.Name, err)
+			return nil
+		}
+		if options == nil {
+			continue
+		}
+		for _, ref := range options.Certificates {
+			if ref.Secret != "" {
+				referenced[ref.Secret] = true
+			}
+		}
+	}
+
+	resp, err := lb.provider.Request(ctx, "GET", "/certificates", nil)
+	if err != nil {
+		return fmt.Errorf("failed to list certificates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return newAPIError(resp)
+	}
+
+	var listResp CertificateListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	var errs []error
+	for _, certificate := range listResp.Data.Certificates {
+		tags := certificate.Tags
+		secretName := tags[TagSecretName]
+		if tags[TagClusterID] != lb.provider.clusterID || tags[TagServiceNamespace] != service.Namespace ||
+			secretName == "" || tags[TagRetained] != "" || referenced[secretName] {
+			continue
+		}
+		if err := lb.deleteCertificate(ctx, service, certificate.Name, secretName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return nil
+}
+
+// deleteCertificate deletes a certificate uploaded from a Secret, nil if it does not exist
+func (lb *VCloudLoadBalancer) deleteCertificate(ctx context.Context, service *v1.Service, name, secretName string) error {
+	klog.V(2).Infof("Deleting certificate %s, secret %s/%s is no longer used", name, service.Namespace, secretName)
+
+	resp, err := lb.provider.Request(ctx, "DELETE", fmt.Sprintf("/certificates/%s", name), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 404 is OK - already deleted
+	if resp.StatusCode == 404 {
+		klog.V(4).Infof("Certificate %s already deleted", name)
+		return nil
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed to delete certificate %s: %w", name, newAPIError(resp))
+	}
+
+	lb.provider.eventf(service, v1.EventTypeNormal, eventReasonTLSCertificateDeleted,
+		"Deleted certificate %s of secret %s, no service uses it any more", name, secretName)
+	return nil
+}
+
+// retainCertificates marks the certificates uploaded from the Secrets of the service as retained, so they
+// are kept for its retained load balancer
+func (lb *VCloudLoadBalancer) retainCertificates(ctx context.Context, service *v1.Service) error {
+	options, err := getTLSOptions(service)
+	if err != nil || options == nil {
+		return nil
+	}
+
+	patch := map[string]interface{}{
+		"tags": map[string]string{TagRetained: "true"},
+	}
+	for _, ref := range options.Certificates {
+		if ref.Secret == "" {
+			continue
+		}
+		name := buildCertificateName(lb.provider.clusterName, service.Namespace, ref.Secret)
+		path := fmt.Sprintf("/certificates/%s", name)
+		if _, err := lb.certificateRequest(ctx, service, "PATCH", path, "Retaining certificate "+name, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlsSecretLister returns the lister of the kubernetes.io/tls Secrets, starting their informer on first use
+// so clusters without TLS termination neither cache nor need access to Secrets
+func (p *VCloudProvider) tlsSecretLister(ctx context.Context) (corelisters.SecretLister, error) {
+	p.mu.Lock()
+	if p.secretsInformer == nil {
+		if p.kubeClient == nil {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("kubernetes client not set yet")
+		}
+		klog.V(2).Infof("Starting the informer of TLS secrets")
+		factory := informers.NewSharedInformerFactoryWithOptions(p.kubeClient, 0,
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("type", string(v1.SecretTypeTLS)).String()
+			}))
+		secrets := factory.Core().V1().Secrets()
+		p.secretsInformer = secrets.Informer()
+		p.watchTLSSecrets(p.secretsInformer)
+		factory.Start(p.stop)
+	}
+	informer := p.secretsInformer
+	p.mu.Unlock()
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil, fmt.Errorf("TLS secret informer not synced")
+	}
+	return corelisters.NewSecretLister(informer.GetIndexer()), nil
+}
+
+// watchTLSSecrets requeues the services using a kubernetes.io/tls Secret when its certificate changes,
+// so the uploaded certificate is rotated
+func (p *VCloudProvider) watchTLSSecrets(informer cache.SharedIndexInformer) {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, ok := oldObj.(*v1.Secret)
+			if !ok {
+				return
+			}
+			secret, ok := newObj.(*v1.Secret)
+			if !ok || secret.Type != v1.SecretTypeTLS {
+				return
+			}
+			if bytes.Equal(oldSecret.Data[v1.TLSCertKey], secret.Data[v1.TLSCertKey]) &&
+				bytes.Equal(oldSecret.Data[v1.TLSPrivateKeyKey], secret.Data[v1.TLSPrivateKeyKey]) {
+				return
+			}
+			if err := p.requeueSecretServices(secret); err != nil {
+				klog.Errorf("Failed to requeue the services of secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			}
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch TLS secrets, certificates will only be rotated when their services are synced: %v", err)
+	}
+}
+
+// requeueSecretServices requeues the LoadBalancer services of the Secret namespace referencing it
+func (p *VCloudProvider) requeueSecretServices(secret *v1.Secret) error {
+	p.mu.RLock()
+	serviceLister := p.serviceLister
+	p.mu.RUnlock()
+
+	if serviceLister == nil {
+		klog.V(3).Infof("Service informer not set yet, not requeueing the services of secret %s/%s", secret.Namespace, secret.Name)
+		return nil
+	}
+
+	services, err := serviceLister.Services(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	for _, service := range services {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		options, err := getTLSOptions(service)
+		if err != nil || options == nil {
+			continue
+		}
+		for _, ref := range options.Certificates {
+			if ref.Secret != secret.Name {
+				continue
+			}
+			klog.V(2).Infof("Requeueing service %s/%s after change of secret %s", service.Namespace, service.Name, secret.Name)
+			p.requeueService(service)
+			break
+		}
+	}
+	return nil
+}
@@ -17,13 +17,24 @@ limitations under the License.
 package vcloud
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
 )
@@ -31,6 +42,9 @@ import (
 const (
 	ProviderName = "vcloud"
 
+	// clientName is the name used to build the provider's kubernetes client
+	clientName = "vcloud-cloud-provider"
+
 	// HTTP client settings
 	defaultTimeout = 60 * time.Second
 	maxRetries     = 3
@@ -40,6 +54,8 @@ const (
 	nonExistentCacheTTL = 5 * time.Second
 )
 
+var _ cloudprovider.RequeueUser = &VCloudProvider{}
+
 // VCloudProvider implements the cloud provider interface for VCloud
 type VCloudProvider struct {
 	clusterName   string
@@ -48,6 +64,35 @@ type VCloudProvider struct {
 	providerToken string
 	httpClient    *http.Client
 
+	// foreignInstancePolicy controls how instances of other clusters are reported
+	foreignInstancePolicy string
+
+	// Serve expired instance data for up to cacheMaxStaleness when the mgmt API fails
+	cacheStaleOnError bool
+	cacheMaxStaleness time.Duration
+
+	// callbackToken authenticates change notifications sent by the mgmt API
+	callbackToken string
+
+	// watchInstances keeps the instance cache current from a watch on the mgmt API
+	watchInstances bool
+
+	// Default frontend networks of external and internal load balancers
+	externalNetwork frontendNetwork
+	internalNetwork frontendNetwork
+
+	// Kubernetes access, set up by Initialize, SetInformers and the controllers registering their
+	// requeue functions. The TLS Secret informer is only started once a service references a Secret.
+	mu               sync.RWMutex
+	stop             <-chan struct{}
+	kubeClient       clientset.Interface
+	recorder         record.EventRecorder
+	nodeLister       corelisters.NodeLister
+	serviceLister    corelisters.ServiceLister
+	secretsInformer  cache.SharedIndexInformer
+	serviceRequeuers []func(*v1.Service)
+	nodeRequeuers    []func(*v1.Node)
+
 	// Sub-interfaces
 	instances    cloudprovider.InstancesV2
 	loadbalancer cloudprovider.LoadBalancer
@@ -79,8 +124,17 @@ func NewVCloudProvider(config io.Reader) (cloudprovider.Interface, error) {
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
+		foreignInstancePolicy: cfg.ForeignInstancePolicy,
+		cacheStaleOnError:     cfg.CacheStaleOnError,
+		cacheMaxStaleness:     cfg.CacheMaxStaleness,
+		callbackToken:         cfg.CallbackToken,
+		watchInstances:        cfg.WatchInstances,
+		externalNetwork:       frontendNetwork{Network: cfg.ExternalNetwork, Subnet: cfg.ExternalSubnet},
+		internalNetwork:       frontendNetwork{Network: cfg.InternalNetwork, Subnet: cfg.InternalSubnet},
 	}
 
+	registerMetrics()
+
 	// Initialize sub-interfaces
 	provider.instances = NewVCloudInstances(provider)
 	provider.loadbalancer = NewVCloudLoadBalancer(provider)
@@ -92,6 +146,77 @@ func NewVCloudProvider(config io.Reader) (cloudprovider.Interface, error) {
 // Initialize provides the cloud with a kubernetes client builder
 func (p *VCloudProvider) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
 	klog.V(3).Infof("Initializing VCloud provider")
+
+	p.mu.Lock()
+	p.stop = stop
+	p.mu.Unlock()
+
+	if p.watchInstances {
+		if instances, ok := p.instances.(*VCloudInstances); ok {
+			go newInstanceWatcher(p, instances.cache).Run(stop)
+		}
+	}
+
+	kubeClient, err := clientBuilder.Client(clientName)
+	if err != nil {
+		klog.Errorf("Failed to create kubernetes client, events will not be recorded: %v", err)
+		return
+	}
+
+	broadcaster := record.NewBroadcaster(record.WithContext(wait.ContextForChannel(stop)))
+	broadcaster.StartStructuredLogging(0)
+	broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	go func() {
+		<-stop
+		broadcaster.Shutdown()
+	}()
+
+	p.mu.Lock()
+	p.kubeClient = kubeClient
+	p.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: clientName})
+	p.mu.Unlock()
+}
+
+// SetInformers sets the informers used to resolve change notifications to Kubernetes objects and to
+// requeue services when node labels change
+func (p *VCloudProvider) SetInformers(informerFactory informers.SharedInformerFactory) {
+	klog.V(3).Infof("Setting informers for VCloud provider")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	nodes := informerFactory.Core().V1().Nodes()
+	p.nodeLister = nodes.Lister()
+	p.watchNodeLabels(nodes.Informer())
+	p.serviceLister = informerFactory.Core().V1().Services().Lister()
+}
+
+// AddServiceRequeueFunc registers a function of the service controller requeueing a service after a
+// change notification
+func (p *VCloudProvider) AddServiceRequeueFunc(requeue func(service *v1.Service)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.serviceRequeuers = append(p.serviceRequeuers, requeue)
+}
+
+// AddNodeRequeueFunc registers a function of a node controller requeueing a node after a change of
+// its instance
+func (p *VCloudProvider) AddNodeRequeueFunc(requeue func(node *v1.Node)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodeRequeuers = append(p.nodeRequeuers, requeue)
+}
+
+// HTTPHandlers returns the endpoints served by the provider on the secure port
+func (p *VCloudProvider) HTTPHandlers() map[string]http.Handler {
+	if p.callbackToken == "" {
+		klog.V(3).Infof("CALLBACK_TOKEN is not set, change notification endpoint disabled")
+		return nil
+	}
+
+	return map[string]http.Handler{
+		notificationPath: newNotificationHandler(p),
+	}
 }
 
 // LoadBalancer returns a LoadBalancer interface if supported
@@ -134,9 +259,8 @@ func (p *VCloudProvider) HasClusterID() bool {
 	return p.clusterID != ""
 }
 
-// Request makes an HTTP request to the VCloud API with retry logic
-func (p *VCloudProvider) Request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
-	// Construct the full URL
+// apiURL constructs the full URL of a cluster scoped API path
+func (p *VCloudProvider) apiURL(path string) string {
 	url := p.mgmtURL
 	if !strings.Contains(p.mgmtURL, "/clusters/") {
 		url = fmt.Sprintf("%s/clusters/%s", p.mgmtURL, p.clusterID)
@@ -144,12 +268,37 @@ func (p *VCloudProvider) Request(ctx context.Context, method, path string, body
 	if path != "" {
 		url = fmt.Sprintf("%s%s", url, path)
 	}
+	return url
+}
+
+// Request makes an HTTP request to the VCloud API. Idempotent requests are retried on transport errors
+// and server errors; POST and PATCH are sent once, as a retry after a timeout could apply them twice, and
+// the callers recover from their failures by reading the current state on the next sync.
+func (p *VCloudProvider) Request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	url := p.apiURL(path)
+
+	// The body is sent again on every attempt
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %v", err)
+		}
+	}
+	attempts := 1
+	if isIdempotentMethod(method) {
+		attempts = maxRetries
+	}
 
 	var resp *http.Response
 	var err error
 
-	for i := 0; i < maxRetries; i++ {
-		req, err := http.NewRequestWithContext(ctx, method, url, body)
+	for i := 0; i < attempts; i++ {
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %v", err)
 		}
@@ -160,8 +309,8 @@ func (p *VCloudProvider) Request(ctx context.Context, method, path string, body
 
 		resp, err = p.httpClient.Do(req)
 		if err != nil {
-			klog.V(4).Infof("Request failed (attempt %d/%d): %v", i+1, maxRetries, err)
-			if i < maxRetries-1 {
+			klog.V(4).Infof("Request failed (attempt %d/%d): %v", i+1, attempts, err)
+			if i < attempts-1 {
 				time.Sleep(time.Duration(i+1) * time.Second)
 				continue
 			}
@@ -169,9 +318,9 @@ func (p *VCloudProvider) Request(ctx context.Context, method, path string, body
 		}
 
 		// Check if we need to retry based on status code
-		if resp.StatusCode >= 500 && i < maxRetries-1 {
+		if resp.StatusCode >= 500 && i < attempts-1 {
 			resp.Body.Close()
-			klog.V(4).Infof("Server error %d, retrying (attempt %d/%d)", resp.StatusCode, i+1, maxRetries)
+			klog.V(4).Infof("Server error %d, retrying (attempt %d/%d)", resp.StatusCode, i+1, attempts)
 			time.Sleep(time.Duration(i+1) * time.Second)
 			continue
 		}
@@ -181,3 +330,12 @@ func (p *VCloudProvider) Request(ctx context.Context, method, path string, body
 
 	return resp, err
 }
+
+// isIdempotentMethod checks if a request with the method can be retried without changing its effect
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
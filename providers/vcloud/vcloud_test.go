@@ -18,18 +18,42 @@ package vcloud
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/api"
 )
 
+const testClusterID = "d73c6df2-f7fe-4f7c-bf70-9f94cce26430"
+
 func TestNewVCloudProvider(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -85,7 +109,7 @@ PROVIDER_TOKEN = test-token`,
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var reader *strings.Reader
+			var reader io.Reader
 			if tt.config != "" {
 				reader = strings.NewReader(tt.config)
 			}
@@ -150,6 +174,53 @@ func TestProviderInterface(t *testing.T) {
 	}
 }
 
+func TestRequestRetries(t *testing.T) {
+	tests := []struct {
+		method       string
+		wantAttempts int
+		wantStatus   int
+	}{
+		{method: "PUT", wantAttempts: 2, wantStatus: http.StatusOK},
+		{method: "POST", wantAttempts: 1, wantStatus: http.StatusServiceUnavailable},
+		{method: "PATCH", wantAttempts: 1, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if len(bodies) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+
+			resp, err := provider.Request(context.Background(), tt.method, "/ingresses/web", strings.NewReader(`{"name":"web"}`))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if len(bodies) != tt.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tt.wantAttempts, len(bodies))
+			}
+			// Every attempt sends the whole body
+			for i, body := range bodies {
+				if body != `{"name":"web"}` {
+					t.Errorf("attempt %d sent body %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
 func TestGetProviderID(t *testing.T) {
 	provider := createTestProvider(t)
 	instances := &VCloudInstances{provider: provider}
@@ -228,18 +299,164 @@ func TestLoadBalancerName(t *testing.T) {
 	provider := createTestProvider(t)
 	lb := &VCloudLoadBalancer{provider: provider}
 
+	newService := func(namespace, name, uid string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid)}}
+	}
+	long := strings.Repeat("very-long-service-name-", 5)
+
+	tests := []struct {
+		name        string
+		clusterName string
+		service     *v1.Service
+		expected    string
+	}{
+		{
+			name:        "namespace and name",
+			clusterName: "cluster",
+			service:     newService("default", "test-service", "abc123-def456-ghi789"),
+			expected:    "cluster-lb-default-test-service-039c09db1a",
+		},
+		{
+			name:        "cloud config cluster name",
+			clusterName: "",
+			service:     newService("default", "test-service", "abc123-def456-ghi789"),
+			expected:    "test-cluster-lb-default-test-service-039c09db1a",
+		},
+		{
+			name:        "invalid characters",
+			clusterName: "Prod_Cluster.EU",
+			service:     newService("default", "test-service", "abc123-def456-ghi789"),
+			expected:    "prod-cluster-eu-lb-default-test-service-039c09db1a",
+		},
+		{
+			name:        "long names are truncated",
+			clusterName: "a-very-long-cluster-name-indeed",
+			service:     newService("default", long, "abc123-def456-ghi789"),
+			expected:    "a-very-long-cluster-lb-default-very-long-service-nam-3efad8d325",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := lb.GetLoadBalancerName(context.Background(), tt.clusterName, tt.service)
+			if name != tt.expected {
+				t.Errorf("expected load balancer name %q, got %q", tt.expected, name)
+			}
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				t.Errorf("load balancer name %q is invalid: %v", name, errs)
+			}
+			if !isLoadBalancerNameOf(name, tt.service, provider.clusterName) {
+				t.Errorf("load balancer name %q does not match its service", name)
+			}
+		})
+	}
+
+	// Services whose names only differ in truncated or invalid characters get different names
+	pairs := [][2]*v1.Service{
+		{newService("default", "web.api", "abc123"), newService("default", "web-api", "abc123")},
+		{newService("default", long+"a", "abc123"), newService("default", long+"b", "abc123")},
+		{newService("team-a", "web", "abc123"), newService("team", "a-web", "abc123")},
+	}
+	for _, pair := range pairs {
+		first := lb.GetLoadBalancerName(context.Background(), "cluster", pair[0])
+		second := lb.GetLoadBalancerName(context.Background(), "cluster", pair[1])
+		if first == second {
+			t.Errorf("services %s/%s and %s/%s share the load balancer name %q",
+				pair[0].Namespace, pair[0].Name, pair[1].Namespace, pair[1].Name, first)
+		}
+	}
+}
+
+func TestEnsureLoadBalancerMigration(t *testing.T) {
 	service := &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "test-service",
-			UID:  types.UID("abc123-def456-ghi789"),
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+	const (
+		legacyName = "test-cluster-ingress-abc123-web"
+		newName    = "kubernetes-lb-default-web-e1f24c5f89"
+	)
+
+	tests := []struct {
+		name         string
+		renameStatus int
+		wantRequests []string
+		wantReason   string
+	}{
+		{
+			name:         "renamed",
+			renameStatus: http.StatusOK,
+			wantRequests: []string{
+				"GET " + newName,
+				"GET " + legacyName,
+				"PATCH " + legacyName,
+				"PATCH " + newName,
+			},
+			wantReason: eventReasonLoadBalancerRenamed,
+		},
+		{
+			name:         "adopted",
+			renameStatus: http.StatusMethodNotAllowed,
+			wantRequests: []string{
+				"GET " + newName,
+				"GET " + legacyName,
+				"PATCH " + legacyName,
+				"PATCH " + legacyName,
+			},
+			wantReason: eventReasonLoadBalancerAdopted,
 		},
 	}
 
-	name := lb.GetLoadBalancerName(context.Background(), "cluster", service)
-	expected := "test-cluster-ingress-abc123-test-service"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			renamed := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/ingresses/")
+				requests = append(requests, r.Method+" "+name)
+				if r.Method == "GET" && name != legacyName {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Method == "PATCH" && name == legacyName && !renamed {
+					renamed = true
+					w.WriteHeader(tt.renameStatus)
+					if tt.renameStatus != http.StatusOK {
+						return
+					}
+				}
+				fmt.Fprint(w, `{"status": 200, "data": {"ingress": [{"ip": "203.0.113.10"}]}}`)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			if name := lb.GetLoadBalancerName(context.Background(), "kubernetes", service); name != newName {
+				t.Fatalf("expected load balancer name %q, got %q", newName, name)
+			}
+			if _, err := lb.EnsureLoadBalancer(context.Background(), "kubernetes", service, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantRequests, requests); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
 
-	if name != expected {
-		t.Errorf("expected load balancer name %q, got %q", expected, name)
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected %s event, got %q", tt.wantReason, event)
+				}
+			default:
+				t.Errorf("expected %s event, got none", tt.wantReason)
+			}
+		})
 	}
 }
 
@@ -292,7 +509,10 @@ func TestBuildLoadBalancerRequest(t *testing.T) {
 		},
 	}
 
-	req := lb.buildLoadBalancerRequest("test-lb", service, nodes)
+	req, err := lb.buildLoadBalancerRequest("test-lb", service, nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	// Verify basic fields
 	if req.Name != "test-lb" {
@@ -333,21 +553,3208 @@ func TestBuildLoadBalancerRequest(t *testing.T) {
 	}
 }
 
-// Helper functions
+func TestBuildLoadBalancerRequestIPFamilies(t *testing.T) {
+	provider := createTestProvider(t)
+	lb := &VCloudLoadBalancer{provider: provider}
 
-func createTestProvider(t *testing.T) *VCloudProvider {
-	config := `[vCloud]
-CLUSTER_ID = d73c6df2-f7fe-4f7c-bf70-9f94cce26430
-CLUSTER_NAME = test-cluster
-MGMT_URL = https://api.vcloud.example.com
-PROVIDER_TOKEN = test-token`
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "10.0.1.100"},
+					{Type: v1.NodeInternalIP, Address: "fd00::100"},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "10.0.1.101"},
+				},
+			},
+		},
+	}
+	ipv4Nodes := nodes[1:]
 
-	provider, err := NewVCloudProvider(strings.NewReader(config))
-	if err != nil {
-		t.Fatalf("failed to create test provider: %v", err)
+	policyPtr := func(p v1.IPFamilyPolicy) *v1.IPFamilyPolicy { return &p }
+	dualStack := []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol}
+
+	tests := []struct {
+		name          string
+		families      []v1.IPFamily
+		policy        *v1.IPFamilyPolicy
+		nodes         []*v1.Node
+		wantFrontends []LoadBalancerFrontend
+		wantBackends  []LoadBalancerBackend
+		wantNodes     []string
+		wantErr       bool
+	}{
+		{
+			name:          "no families defaults to IPv4",
+			nodes:         nodes,
+			wantFrontends: []LoadBalancerFrontend{{IPFamily: "IPv4"}},
+			wantBackends: []LoadBalancerBackend{
+				{Node: "node-1", IPFamily: "IPv4", Address: "10.0.1.100"},
+				{Node: "node-2", IPFamily: "IPv4", Address: "10.0.1.101"},
+			},
+			wantNodes: []string{"10.0.1.100", "10.0.1.101"},
+		},
+		{
+			name:          "single stack uses the primary family",
+			families:      dualStack,
+			policy:        policyPtr(v1.IPFamilyPolicySingleStack),
+			nodes:         nodes,
+			wantFrontends: []LoadBalancerFrontend{{IPFamily: "IPv6"}},
+			wantBackends:  []LoadBalancerBackend{{Node: "node-1", IPFamily: "IPv6", Address: "fd00::100"}},
+			wantNodes:     []string{"fd00::100"},
+		},
+		{
+			name:          "dual stack sends backends per family",
+			families:      dualStack,
+			policy:        policyPtr(v1.IPFamilyPolicyRequireDualStack),
+			nodes:         nodes,
+			wantFrontends: []LoadBalancerFrontend{{IPFamily: "IPv6"}, {IPFamily: "IPv4"}},
+			wantBackends: []LoadBalancerBackend{
+				{Node: "node-1", IPFamily: "IPv6", Address: "fd00::100"},
+				{Node: "node-1", IPFamily: "IPv4", Address: "10.0.1.100"},
+				{Node: "node-2", IPFamily: "IPv4", Address: "10.0.1.101"},
+			},
+			wantNodes: []string{"fd00::100"},
+		},
+		{
+			name:          "prefer dual stack drops families without node addresses",
+			families:      []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+			policy:        policyPtr(v1.IPFamilyPolicyPreferDualStack),
+			nodes:         ipv4Nodes,
+			wantFrontends: []LoadBalancerFrontend{{IPFamily: "IPv4"}},
+			wantBackends:  []LoadBalancerBackend{{Node: "node-2", IPFamily: "IPv4", Address: "10.0.1.101"}},
+			wantNodes:     []string{"10.0.1.101"},
+		},
+		{
+			name:     "require dual stack fails without node addresses",
+			families: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+			policy:   policyPtr(v1.IPFamilyPolicyRequireDualStack),
+			nodes:    ipv4Nodes,
+			wantErr:  true,
+		},
 	}
 
-	return provider.(*VCloudProvider)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+				Spec: v1.ServiceSpec{
+					Type:           v1.ServiceTypeLoadBalancer,
+					IPFamilies:     tt.families,
+					IPFamilyPolicy: tt.policy,
+				},
+			}
+
+			req, err := lb.buildLoadBalancerRequest("test-lb", service, tt.nodes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantFrontends, req.Frontends); diff != "" {
+				t.Errorf("unexpected frontends (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantBackends, req.Backends); diff != "" {
+				t.Errorf("unexpected backends (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantNodes, req.Nodes); diff != "" {
+				t.Errorf("unexpected nodes (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBackendTopology(t *testing.T) {
+	newNode := func(name, address, zone, cpu string) *v1.Node {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}}},
+		}
+		if cpu != "" {
+			node.Status.Capacity = v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}
+		}
+		return node
+	}
+	nodes := []*v1.Node{
+		newNode("node-a1", "10.0.1.1", "zone-a", "4"),
+		newNode("node-a2", "10.0.1.2", "zone-a", "12"),
+		newNode("node-b1", "10.0.2.1", "zone-b", ""),
+	}
+
+	tests := []struct {
+		name       string
+		zonePolicy string
+		want       []LoadBalancerBackend
+	}{
+		{
+			name: "weighted by capacity",
+			want: []LoadBalancerBackend{
+				{Node: "node-a1", IPFamily: "IPv4", Address: "10.0.1.1", Zone: "zone-a", Weight: 4},
+				{Node: "node-a2", IPFamily: "IPv4", Address: "10.0.1.2", Zone: "zone-a", Weight: 12},
+				{Node: "node-b1", IPFamily: "IPv4", Address: "10.0.2.1", Zone: "zone-b"},
+			},
+		},
+		{
+			name:       "prefer same zone",
+			zonePolicy: ZonePolicyPreferSameZone,
+			want: []LoadBalancerBackend{
+				{Node: "node-a1", IPFamily: "IPv4", Address: "10.0.1.1", Zone: "zone-a", Weight: 4},
+				{Node: "node-a2", IPFamily: "IPv4", Address: "10.0.1.2", Zone: "zone-a", Weight: 12},
+				{Node: "node-b1", IPFamily: "IPv4", Address: "10.0.2.1", Zone: "zone-b"},
+			},
+		},
+		{
+			name:       "spread evenly across zones",
+			zonePolicy: ZonePolicySpread,
+			want: []LoadBalancerBackend{
+				{Node: "node-a1", IPFamily: "IPv4", Address: "10.0.1.1", Zone: "zone-a", Weight: 25},
+				{Node: "node-a2", IPFamily: "IPv4", Address: "10.0.1.2", Zone: "zone-a", Weight: 75},
+				{Node: "node-b1", IPFamily: "IPv4", Address: "10.0.2.1", Zone: "zone-b", Weight: 100},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &VCloudLoadBalancer{provider: createTestProvider(t)}
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: v1.ServiceSpec{
+					Type:  v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+				},
+			}
+			if tt.zonePolicy != "" {
+				service.Annotations = map[string]string{ServiceAnnotationLoadBalancerZonePolicy: tt.zonePolicy}
+			}
+
+			req, err := lb.buildLoadBalancerRequest("test-lb", service, nodes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, req.Backends); diff != "" {
+				t.Errorf("unexpected backends (-want +got):\n%s", diff)
+			}
+			if req.ZonePolicy != tt.zonePolicy {
+				t.Errorf("expected zone policy %q, got %q", tt.zonePolicy, req.ZonePolicy)
+			}
+		})
+	}
+}
+
+func TestValidatePorts(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name        string
+		ports       []v1.ServicePort
+		annotations map[string]string
+		wantErrs    []string
+	}{
+		{
+			name: "mixed TCP and UDP",
+			ports: []v1.ServicePort{
+				{Name: "dns-tcp", Port: 53, Protocol: v1.ProtocolTCP, NodePort: 30053},
+				{Name: "dns-udp", Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30054},
+				{Name: "web", Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443, AppProtocol: strPtr("HTTPS")},
+			},
+		},
+		{
+			name: "SCTP",
+			ports: []v1.ServicePort{
+				{Name: "sigtran", Port: 2905, Protocol: v1.ProtocolSCTP, NodePort: 32905},
+			},
+			wantErrs: []string{"port sigtran uses protocol SCTP"},
+		},
+		{
+			name: "unsupported app protocols",
+			ports: []v1.ServicePort{
+				{Port: 3306, Protocol: v1.ProtocolTCP, NodePort: 33306, AppProtocol: strPtr("mysql")},
+				{Name: "quic", Port: 443, Protocol: v1.ProtocolUDP, NodePort: 30443, AppProtocol: strPtr("http3")},
+			},
+			wantErrs: []string{`port 3306 has appProtocol "mysql", TCP ports support http,`, `port quic has appProtocol "http3", UDP ports support no appProtocol`},
+		},
+		{
+			name: "PROXY protocol with UDP",
+			ports: []v1.ServicePort{
+				{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+			},
+			annotations: map[string]string{ServiceAnnotationLoadBalancerProxyProtocol: "true"},
+			wantErrs:    []string{"port dns uses UDP, " + ServiceAnnotationLoadBalancerProxyProtocol},
+		},
+		{
+			name: "no node port",
+			ports: []v1.ServicePort{
+				{Name: "http", Port: 80, Protocol: v1.ProtocolTCP},
+			},
+			wantErrs: []string{"port http has no node port"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, Ports: tt.ports},
+			}
+
+			// Unsupported ports never reach the mgmt API
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(tt.wantErrs) > 0 {
+					t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			_, err := lb.buildRequest(context.Background(), "test-lb", service, nil)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var nonRetryable *api.NonRetryableError
+			if !errors.As(err, &nonRetryable) {
+				t.Fatalf("expected a NonRetryableError, got %v", err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error mentioning %q, got %q", want, err.Error())
+				}
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, eventReasonUnsupportedProtocol) {
+					t.Errorf("expected %s event, got %q", eventReasonUnsupportedProtocol, event)
+				}
+			default:
+				t.Errorf("expected %s event, got none", eventReasonUnsupportedProtocol)
+			}
+		})
+	}
+}
+
+func TestSessionAffinity(t *testing.T) {
+	timeout := int32(600)
+
+	tests := []struct {
+		name            string
+		sessionAffinity v1.ServiceAffinity
+		config          *v1.SessionAffinityConfig
+		want            *LoadBalancerPersistence
+	}{
+		{
+			name:            "no session affinity",
+			sessionAffinity: v1.ServiceAffinityNone,
+		},
+		{
+			name:            "client IP with default timeout",
+			sessionAffinity: v1.ServiceAffinityClientIP,
+			want:            &LoadBalancerPersistence{Type: PersistenceSourceIP, Timeout: v1.DefaultClientIPServiceAffinitySeconds},
+		},
+		{
+			name:            "client IP with timeout",
+			sessionAffinity: v1.ServiceAffinityClientIP,
+			config:          &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}},
+			want:            &LoadBalancerPersistence{Type: PersistenceSourceIP, Timeout: 600},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &VCloudLoadBalancer{provider: createTestProvider(t)}
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: v1.ServiceSpec{
+					Type:                  v1.ServiceTypeLoadBalancer,
+					Ports:                 []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+					SessionAffinity:       tt.sessionAffinity,
+					SessionAffinityConfig: tt.config,
+				},
+			}
+
+			req, err := lb.buildLoadBalancerRequest("test-lb", service, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, req.Persistence); diff != "" {
+				t.Errorf("unexpected persistence (-want +got):\n%s", diff)
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			// Enabling session affinity is reconciled as a change of persistence
+			previous := *req
+			previous.Persistence = nil
+			changes, err := diffLoadBalancerRequest(&previous, req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changes.String() != "persistence" {
+				t.Errorf("expected a persistence change, got %q", changes.String())
+			}
+		})
+	}
+}
+
+func TestBuildLoadBalancerStatus(t *testing.T) {
+	lbResp := &LoadBalancerResponse{}
+	lbResp.Data.Ingress = []LoadBalancerIngress{
+		{IP: "203.0.113.10"},
+		{IP: "2001:db8::10", IPFamily: "IPv6"},
+		{IP: "203.0.113.10"},
+		{IP: ""},
+		{Hostname: "web.lb.vcloud.example.com"},
+	}
+
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{
+			IPFamilies:     []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol},
+			IPFamilyPolicy: func() *v1.IPFamilyPolicy { p := v1.IPFamilyPolicyPreferDualStack; return &p }(),
+		},
+	}
+
+	want := &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{IP: "2001:db8::10"}, {IP: "203.0.113.10"}, {Hostname: "web.lb.vcloud.example.com"}},
+	}
+	if diff := cmp.Diff(want, buildLoadBalancerStatus(service, lbResp)); diff != "" {
+		t.Errorf("unexpected status (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuildLoadBalancerIngress(t *testing.T) {
+	vip := v1.LoadBalancerIPModeVIP
+	proxy := v1.LoadBalancerIPModeProxy
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		ingress LoadBalancerIngress
+		want    v1.LoadBalancerIngress
+	}{
+		{
+			name:    "IP only",
+			ingress: LoadBalancerIngress{IP: "203.0.113.10"},
+			want:    v1.LoadBalancerIngress{IP: "203.0.113.10"},
+		},
+		{
+			name:    "hostname and VIP mode",
+			ingress: LoadBalancerIngress{IP: "203.0.113.10", Hostname: "web.lb.vcloud.example.com", IPMode: "vip"},
+			want:    v1.LoadBalancerIngress{IP: "203.0.113.10", Hostname: "web.lb.vcloud.example.com", IPMode: &vip},
+		},
+		{
+			name:    "proxy mode",
+			ingress: LoadBalancerIngress{IP: "203.0.113.10", IPMode: "Proxy"},
+			want:    v1.LoadBalancerIngress{IP: "203.0.113.10", IPMode: &proxy},
+		},
+		{
+			name:    "ipMode without IP and unknown ipMode are dropped",
+			ingress: LoadBalancerIngress{Hostname: "web.lb.vcloud.example.com", IPMode: "VIP"},
+			want:    v1.LoadBalancerIngress{Hostname: "web.lb.vcloud.example.com"},
+		},
+		{
+			name: "port status errors",
+			ingress: LoadBalancerIngress{
+				IP: "203.0.113.10",
+				Ports: []LoadBalancerPortStatus{
+					{Port: 80, Protocol: "tcp"},
+					{Port: 53, Protocol: "udp", Error: "ProtocolNotSupported"},
+					{Port: 443, Protocol: "tcp", Error: "certificate upload failed"},
+					{Port: 8080, Protocol: "tcp", Error: "example.com/PortInUse"},
+				},
+			},
+			want: v1.LoadBalancerIngress{
+				IP: "203.0.113.10",
+				Ports: []v1.PortStatus{
+					{Port: 80, Protocol: v1.ProtocolTCP},
+					{Port: 53, Protocol: v1.ProtocolUDP, Error: strPtr("k8s.io.infra.vnetwork.dev/ProtocolNotSupported")},
+					{Port: 443, Protocol: v1.ProtocolTCP, Error: strPtr(portErrorUnknown)},
+					{Port: 8080, Protocol: v1.ProtocolTCP, Error: strPtr("example.com/PortInUse")},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, buildLoadBalancerIngress(tt.ingress)); diff != "" {
+				t.Errorf("unexpected ingress (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerProvisioning(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		retryAfter     string
+		stuck          bool
+		wantRetryAfter time.Duration
+		wantErr        bool
+		wantReason     string
+	}{
+		{
+			name: "provisioned",
+			body: `{"status": 200, "data": {"state": "ACTIVE", "ingress": [{"ip": "203.0.113.10"}]}}`,
+		},
+		{
+			name:           "provisioning",
+			body:           `{"status": 200, "data": {"state": "PROVISIONING", "ingress": []}}`,
+			wantRetryAfter: provisioningRetryDelay,
+		},
+		{
+			name:           "no address without state",
+			body:           `{"status": 200, "data": {"ingress": [{"ip": ""}]}}`,
+			retryAfter:     "3",
+			wantRetryAfter: 3 * time.Second,
+		},
+		{
+			name:           "stuck",
+			body:           `{"status": 200, "data": {"state": "pending"}}`,
+			stuck:          true,
+			wantRetryAfter: provisioningRetryDelay,
+			wantReason:     eventReasonLoadBalancerProvisioningStuck,
+		},
+		{
+			name:       "failed",
+			body:       `{"status": 200, "data": {"state": "FAILED", "message": "no capacity"}}`,
+			wantErr:    true,
+			wantReason: eventReasonLoadBalancerProvisioningFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			}
+			if tt.stuck {
+				lbName := lb.GetLoadBalancerName(context.Background(), "kubernetes", service)
+				lb.provisioning = map[string]*provisioningState{
+					lbName: {since: time.Now().Add(-provisioningStuckThreshold)},
+				}
+			}
+
+			status, err := lb.EnsureLoadBalancer(context.Background(), "kubernetes", service, nil)
+
+			var retryErr *api.RetryError
+			switch {
+			case tt.wantRetryAfter != 0:
+				if !errors.As(err, &retryErr) {
+					t.Fatalf("expected RetryError, got %v", err)
+				}
+				if retryErr.RetryAfter() != tt.wantRetryAfter {
+					t.Errorf("expected retry after %v, got %v", tt.wantRetryAfter, retryErr.RetryAfter())
+				}
+			case tt.wantErr:
+				if err == nil || errors.As(err, &retryErr) {
+					t.Fatalf("expected non-retry error, got %v", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(status.Ingress) != 1 {
+					t.Errorf("expected 1 ingress, got %v", status.Ingress)
+				}
+				if len(lb.provisioning) != 0 {
+					t.Errorf("expected provisioning state to be cleared, got %v", lb.provisioning)
+				}
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected event with reason %q, got %q", tt.wantReason, event)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("expected event with reason %q, got none", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffLoadBalancerRequest(t *testing.T) {
+	current := &LoadBalancerRequest{
+		Name:         "test-lb",
+		Ports:        []LoadBalancerPort{{Name: "http", Port: 80, TargetPort: "8080", Protocol: "TCP", NodePort: 30080}},
+		Nodes:        []string{"10.0.1.100"},
+		SourceRanges: nil,
+		Algorithm:    AlgorithmRoundRobin,
+	}
+
+	desired := *current
+	desired.SourceRanges = []string{}
+	diff, err := diffLoadBalancerRequest(current, &desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("expected no diff for empty and omitted fields, got %s", diff)
+	}
+
+	desired.Nodes = []string{"10.0.1.100", "10.0.1.101"}
+	desired.Algorithm = ""
+	desired.IdleTimeout = 60
+	diff, err = diffLoadBalancerRequest(current, &desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff.String() != "algorithm, idleTimeout, nodes" {
+		t.Errorf("expected algorithm, idleTimeout and nodes to change, got %s", diff)
+	}
+
+	wantPatch := map[string]json.RawMessage{
+		"algorithm":   json.RawMessage("null"),
+		"idleTimeout": json.RawMessage("60"),
+		"nodes":       json.RawMessage(`["10.0.1.100","10.0.1.101"]`),
+	}
+	if diff := cmp.Diff(wantPatch, diff.patch()); diff != "" {
+		t.Errorf("unexpected patch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEnsureLoadBalancerReconcile(t *testing.T) {
+	provider := createTestProvider(t)
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+	desired, err := lb.buildLoadBalancerRequest(lb.GetLoadBalancerName(context.Background(), "kubernetes", service), service, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	changed := *desired
+	changed.Algorithm = AlgorithmSourceIP
+
+	tests := []struct {
+		name        string
+		current     *LoadBalancerRequest
+		wantMethods []string
+		wantPatch   string
+	}{
+		{
+			name:        "created",
+			wantMethods: []string{"GET", "GET", "POST"},
+		},
+		{
+			name:        "unchanged",
+			current:     desired,
+			wantMethods: []string{"GET"},
+		},
+		{
+			name:        "updated",
+			current:     &changed,
+			wantMethods: []string{"GET", "PATCH"},
+			wantPatch:   `{"algorithm":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var methods []string
+			var patch string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				if r.Method == "GET" && tt.current == nil {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Method == "PATCH" {
+					body, _ := io.ReadAll(r.Body)
+					patch = string(body)
+				}
+				config, _ := json.Marshal(tt.current)
+				fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+			}))
+			defer server.Close()
+			provider.mgmtURL = server.URL
+
+			if _, err := lb.EnsureLoadBalancer(context.Background(), "kubernetes", service, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantMethods, methods); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+			if patch != tt.wantPatch {
+				t.Errorf("expected patch %s, got %s", tt.wantPatch, patch)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerGarbageCollector(t *testing.T) {
+	provider := createTestProvider(t)
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/clusters/"+testClusterID+"/ingresses":
+			fmt.Fprint(w, `{"status": 200, "data": {"ingresses": [
+				{"name": "kubernetes-lb-default-web-e1f24c5f89", "createdAt": "2024-05-01T10:00:00Z",
+				 "tags": {"k8s.io.infra.vnetwork.dev/cluster-id": "`+testClusterID+`", "k8s.io.infra.vnetwork.dev/service-uid": "abc123-def456"}},
+				{"name": "kubernetes-lb-default-api-6b0d7c1f2e"},
+				{"name": "kubernetes-lb-default-db-5e8a0b9c7d", "tags": {"k8s.io.infra.vnetwork.dev/cluster-id": "other-cluster"}},
+				{"name": "kubernetes-lb-default-old-3c9d4e2a1b", "tags": {"k8s.io.infra.vnetwork.dev/cluster-id": "`+testClusterID+`", "k8s.io.infra.vnetwork.dev/retained": "true"}},
+				{"name": "test-cluster-ingress-abc123-web"},
+				{"name": "manual-ingress"}
+			]}}`)
+		case r.Method == "GET" && r.URL.Path == "/clusters/"+testClusterID+"/ingresses/kubernetes-lb-default-api-6b0d7c1f2e":
+			fmt.Fprint(w, `{"status": 200, "data": {"config": {"name": "kubernetes-lb-default-api-6b0d7c1f2e"}}}`)
+		case r.Method == "GET" && r.URL.Path == "/clusters/"+testClusterID+"/ingresses/kubernetes-lb-default-db-5e8a0b9c7d":
+			fmt.Fprint(w, `{"status": 200, "data": {"config": {"tags": {"k8s.io.infra.vnetwork.dev/cluster-id": "other-cluster"}}}}`)
+		case r.Method == "GET" && r.URL.Path == "/clusters/"+testClusterID+"/ingresses/kubernetes-lb-default-old-3c9d4e2a1b":
+			fmt.Fprint(w, `{"status": 200, "data": {"config": {"tags": {"k8s.io.infra.vnetwork.dev/cluster-id": "`+testClusterID+`", "k8s.io.infra.vnetwork.dev/retained": "true"}}}}`)
+		case r.Method == "DELETE" && r.URL.Path == "/clusters/"+testClusterID+"/ingresses/kubernetes-lb-default-api-6b0d7c1f2e":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/ingresses/"))
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	provider.mgmtURL = server.URL
+
+	loadBalancers, err := lb.ListLoadBalancers(context.Background(), "kubernetes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []cloudprovider.LoadBalancerInfo{
+		{
+			Name:       "kubernetes-lb-default-web-e1f24c5f89",
+			ServiceUID: types.UID("abc123-def456"),
+			CreatedAt:  time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{Name: "kubernetes-lb-default-api-6b0d7c1f2e"},
+	}
+	if diff := cmp.Diff(expected, loadBalancers); diff != "" {
+		t.Errorf("unexpected load balancers (-want +got):\n%s", diff)
+	}
+
+	if err := lb.DeleteLoadBalancer(context.Background(), "kubernetes", "kubernetes-lb-default-api-6b0d7c1f2e"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Load balancers of other clusters are never deleted
+	if err := lb.DeleteLoadBalancer(context.Background(), "kubernetes", "kubernetes-lb-default-db-5e8a0b9c7d"); err == nil {
+		t.Errorf("expected an error deleting the load balancer of another cluster")
+	}
+	// Nor are load balancers released by the Retain policy
+	if err := lb.DeleteLoadBalancer(context.Background(), "kubernetes", "kubernetes-lb-default-old-3c9d4e2a1b"); err == nil {
+		t.Errorf("expected an error deleting a retained load balancer")
+	}
+	// Already deleted load balancers are not an error
+	if err := lb.DeleteLoadBalancer(context.Background(), "kubernetes", "kubernetes-lb-default-web-e1f24c5f89"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"kubernetes-lb-default-api-6b0d7c1f2e"}, deleted); diff != "" {
+		t.Errorf("unexpected deletions (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadBalancerOwnership(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		tags      string
+		wantError bool
+	}{
+		{
+			name: "untagged",
+			tags: `{}`,
+		},
+		{
+			name: "same service",
+			tags: `{"k8s.io.infra.vnetwork.dev/cluster-id": "` + testClusterID + `", "k8s.io.infra.vnetwork.dev/service-uid": "abc123-def456"}`,
+		},
+		{
+			name:      "other service",
+			tags:      `{"k8s.io.infra.vnetwork.dev/cluster-id": "` + testClusterID + `", "k8s.io.infra.vnetwork.dev/service-namespace": "team-a", "k8s.io.infra.vnetwork.dev/service-name": "web", "k8s.io.infra.vnetwork.dev/service-uid": "0ff1ce"}`,
+			wantError: true,
+		},
+		{
+			name:      "other cluster",
+			tags:      `{"k8s.io.infra.vnetwork.dev/cluster-id": "other-cluster", "k8s.io.infra.vnetwork.dev/service-uid": "abc123-def456"}`,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modified []string
+			var tags map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "GET" {
+					modified = append(modified, r.Method)
+				}
+				if r.Method == "PATCH" || r.Method == "PUT" {
+					var body struct {
+						Tags map[string]string `json:"tags"`
+					}
+					json.NewDecoder(r.Body).Decode(&body)
+					tags = body.Tags
+				}
+				fmt.Fprintf(w, `{"status": 200, "data": {"config": {"tags": %s}, "ingress": [{"ip": "203.0.113.10"}]}}`, tt.tags)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			errs := []error{}
+			_, err := lb.EnsureLoadBalancer(context.Background(), "kubernetes", service, nil)
+			errs = append(errs, err)
+			errs = append(errs, lb.UpdateLoadBalancer(context.Background(), "kubernetes", service, nil))
+			errs = append(errs, lb.EnsureLoadBalancerDeleted(context.Background(), "kubernetes", service))
+
+			for _, err := range errs {
+				if tt.wantError != (err != nil) {
+					t.Errorf("expected error %v, got %v", tt.wantError, err)
+				}
+			}
+			if tt.wantError {
+				if len(modified) != 0 {
+					t.Errorf("expected no modifications of a foreign load balancer, got %v", modified)
+				}
+				if len(recorder.Events) != len(errs) {
+					t.Errorf("expected %d events, got %d", len(errs), len(recorder.Events))
+				}
+				for len(recorder.Events) > 0 {
+					if event := <-recorder.Events; !strings.Contains(event, eventReasonLoadBalancerOwnershipConflict) {
+						t.Errorf("unexpected event %q", event)
+					}
+				}
+				return
+			}
+
+			expectedTags := map[string]string{
+				TagClusterID:        testClusterID,
+				TagServiceNamespace: "default",
+				TagServiceName:      "web",
+				TagServiceUID:       "abc123-def456",
+			}
+			if diff := cmp.Diff(expectedTags, tags); diff != "" {
+				t.Errorf("unexpected tags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerAdoption(t *testing.T) {
+	newService := func(annotations map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456"), Annotations: annotations},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+			},
+		}
+	}
+	adopt := map[string]string{ServiceAnnotationLoadBalancerAdopt: "vm-web"}
+	adoptAndDelete := map[string]string{ServiceAnnotationLoadBalancerAdopt: "vm-web", ServiceAnnotationLoadBalancerRetainPolicy: "Delete"}
+
+	tests := []struct {
+		name         string
+		service      *v1.Service
+		exists       bool
+		delete       bool
+		wantRequests []string
+		wantPatch    string
+		wantReason   string
+		wantError    bool
+	}{
+		{
+			name:    "adopt existing ingress",
+			service: newService(adopt),
+			exists:  true,
+			// The ingresses under the previous names of the service are not tagged with it and left alone
+			wantRequests: []string{"GET vm-web", "PATCH vm-web", "GET kubernetes-lb-default-web-e1f24c5f89", "GET test-cluster-ingress-abc123-web"},
+			wantReason:   eventReasonLoadBalancerAdopted,
+		},
+		{
+			name:         "missing ingress is not created",
+			service:      newService(adopt),
+			wantRequests: []string{"GET vm-web"},
+			wantReason:   eventReasonAdoptedLoadBalancerNotFound,
+			wantError:    true,
+		},
+		{
+			name:         "release on deletion",
+			service:      newService(adopt),
+			exists:       true,
+			delete:       true,
+			wantRequests: []string{"GET vm-web", "PATCH vm-web"},
+			wantPatch: `{"backends":null,"nodes":[],"tags":{"k8s.io.infra.vnetwork.dev/cluster-id":"` + testClusterID + `","k8s.io.infra.vnetwork.dev/retained":"true",` +
+				`"k8s.io.infra.vnetwork.dev/service-name":null,"k8s.io.infra.vnetwork.dev/service-namespace":null,"k8s.io.infra.vnetwork.dev/service-uid":null,"k8s.io.infra.vnetwork.dev/sharing-key":null}}`,
+			wantReason: eventReasonLoadBalancerReleased,
+		},
+		{
+			name:         "delete policy",
+			service:      newService(adoptAndDelete),
+			exists:       true,
+			delete:       true,
+			wantRequests: []string{"GET vm-web", "DELETE vm-web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			var patch string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/ingresses/"))
+				if r.Method == "GET" && !tt.exists {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Method == "PATCH" {
+					body, _ := io.ReadAll(r.Body)
+					patch = string(body)
+				}
+				fmt.Fprint(w, `{"status": 200, "data": {"ingress": [{"ip": "203.0.113.10"}]}}`)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			var err error
+			if tt.delete {
+				err = lb.EnsureLoadBalancerDeleted(context.Background(), "kubernetes", tt.service)
+			} else {
+				_, err = lb.EnsureLoadBalancer(context.Background(), "kubernetes", tt.service, nil)
+			}
+			if tt.wantError != (err != nil) {
+				t.Errorf("expected error %v, got %v", tt.wantError, err)
+			}
+			if diff := cmp.Diff(tt.wantRequests, requests); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+			if tt.wantPatch != "" && patch != tt.wantPatch {
+				t.Errorf("expected patch %s, got %s", tt.wantPatch, patch)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected %q event, got %q", tt.wantReason, event)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("expected %s event, got none", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBalancerAdoptionOfClusterNames(t *testing.T) {
+	owner := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a", UID: types.UID("abc123-def456")},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	newService := func(adopt string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   "team-b",
+				UID:         types.UID("789abc-012def"),
+				Annotations: map[string]string{ServiceAnnotationLoadBalancerAdopt: adopt, ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyDelete},
+			},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+			},
+		}
+	}
+
+	// The untagged ingresses of the other namespace are served to any request
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/ingresses/"))
+		fmt.Fprint(w, `{"status": 200, "data": {"ingress": [{"ip": "203.0.113.10"}]}}`)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	for _, name := range []string{legacyLoadBalancerName("test-cluster", owner), buildLoadBalancerName("kubernetes", owner)} {
+		service := newService(name)
+		if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err == nil {
+			t.Errorf("expected an error adopting %s", name)
+		}
+		if err := lb.UpdateLoadBalancer(ctx, "kubernetes", service, nil); err == nil {
+			t.Errorf("expected an error updating %s", name)
+		}
+		if err := lb.EnsureLoadBalancerDeleted(ctx, "kubernetes", service); err == nil {
+			t.Errorf("expected an error deleting %s", name)
+		}
+		for i := 0; i < 3; i++ {
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, eventReasonInvalidAnnotation) {
+					t.Errorf("expected %s event, got %q", eventReasonInvalidAnnotation, event)
+				}
+			default:
+				t.Errorf("expected %s event, got none", eventReasonInvalidAnnotation)
+			}
+		}
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no request to the ingresses of the cluster, got %v", requests)
+	}
+
+	// Other ingresses are still adopted
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", newService("vm-web"), nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRemovePreviousIngresses(t *testing.T) {
+	const previous = "kubernetes-lb-default-web-e1f24c5f89"
+	ownTags := map[string]string{TagClusterID: testClusterID, TagServiceNamespace: "default", TagServiceName: "web", TagServiceUID: "abc123-def456"}
+	otherTags := map[string]string{TagClusterID: testClusterID, TagServiceNamespace: "default", TagServiceName: "web", TagServiceUID: "0ld-uid"}
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		previousTags map[string]string
+		wantRequests []string
+		wantReason   string
+	}{
+		{
+			name:         "shared load balancer deletes the previous ingress",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerSharingKey: "web"},
+			previousTags: ownTags,
+			wantRequests: []string{"GET " + previous, "DELETE " + previous},
+			wantReason:   eventReasonLoadBalancerReplaced,
+		},
+		{
+			name:         "adopted load balancer releases the previous ingress with the Retain policy",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerAdopt: "vm-web", ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetain},
+			previousTags: ownTags,
+			wantRequests: []string{"GET " + previous, "PATCH " + previous},
+			wantReason:   eventReasonLoadBalancerReplaced,
+		},
+		{
+			name:         "deletion protection keeps the previous ingress",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerSharingKey: "web", ServiceAnnotationLoadBalancerDeletionProtection: "true"},
+			previousTags: ownTags,
+			wantRequests: []string{"GET " + previous},
+			wantReason:   eventReasonLoadBalancerDeletionProtected,
+		},
+		{
+			name:         "ingress of another service is kept",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerSharingKey: "web"},
+			previousTags: otherTags,
+			wantRequests: []string{"GET " + previous},
+		},
+		{
+			name:        "own load balancer",
+			annotations: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/ingresses/")
+				if name != previous {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				requests = append(requests, r.Method+" "+name)
+				config, _ := json.Marshal(&LoadBalancerRequest{Name: previous, Tags: tt.previousTags})
+				fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456"), Annotations: tt.annotations},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			}
+			name := lb.GetLoadBalancerName(context.Background(), "kubernetes", service)
+			if err := lb.removePreviousIngresses(context.Background(), "kubernetes", service, name); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantRequests, requests); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected %q event, got %q", tt.wantReason, event)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("expected %s event, got none", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerDeletedRetainPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		wantRequests []string
+		wantReason   string
+		wantError    bool
+	}{
+		{
+			name:         "delete by default",
+			wantRequests: []string{"GET", "DELETE"},
+		},
+		{
+			name:         "retain",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetain},
+			wantRequests: []string{"GET", "PATCH"},
+			wantReason:   eventReasonLoadBalancerReleased,
+		},
+		{
+			name:         "retain IP",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetainIP},
+			wantRequests: []string{"GET", "DELETE retainPublicIPs=true"},
+			wantReason:   eventReasonLoadBalancerIPRetained,
+		},
+		{
+			name:        "deletion protection",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerDeletionProtection: "true"},
+			wantReason:  eventReasonLoadBalancerDeletionProtected,
+			wantError:   true,
+		},
+		{
+			name:        "invalid retain policy",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerRetainPolicy: "Keep"},
+			wantReason:  eventReasonInvalidAnnotation,
+			wantError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The service has no load balancer under its legacy name
+				if strings.HasSuffix(r.URL.Path, "/test-cluster-ingress-abc123-web") {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.RawQuery))
+				fmt.Fprint(w, `{"status": 200, "data": {"ingress": [{"ip": "203.0.113.10"}]}}`)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456"), Annotations: tt.annotations},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			}
+			err := lb.EnsureLoadBalancerDeleted(context.Background(), "kubernetes", service)
+			if tt.wantError != (err != nil) {
+				t.Errorf("expected error %v, got %v", tt.wantError, err)
+			}
+			if diff := cmp.Diff(tt.wantRequests, requests); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected %q event, got %q", tt.wantReason, event)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("expected %s event, got none", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestRetainedLoadBalancerGarbageCollection(t *testing.T) {
+	const name = "kubernetes-lb-default-web-e1f24c5f89"
+	tags := map[string]string{
+		TagClusterID:        testClusterID,
+		TagServiceNamespace: "default",
+		TagServiceName:      "web",
+		TagServiceUID:       "abc123-def456",
+	}
+
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID)
+		switch {
+		case r.Method == "GET" && path == "/ingresses":
+			data, _ := json.Marshal(tags)
+			fmt.Fprintf(w, `{"status": 200, "data": {"ingresses": [{"name": %q, "tags": %s}]}}`, name, data)
+			return
+		case path != "/ingresses/"+name:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case r.Method == "PATCH":
+			// JSON merge patch of the tags
+			var patch struct {
+				Tags map[string]*string `json:"tags"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				t.Errorf("failed to decode patch: %v", err)
+			}
+			for key, value := range patch.Tags {
+				if value == nil {
+					delete(tags, key)
+				} else {
+					tags[key] = *value
+				}
+			}
+		case r.Method == "DELETE":
+			deleted = append(deleted, name)
+		}
+		data, _ := json.Marshal(tags)
+		fmt.Fprintf(w, `{"status": 200, "data": {"config": {"name": %q, "tags": %s}}}`, name, data)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	provider.recorder = record.NewFakeRecorder(10)
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web", Namespace: "default", UID: types.UID("abc123-def456"),
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetain},
+		},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	if err := lb.EnsureLoadBalancerDeleted(context.Background(), "kubernetes", service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantTags := map[string]string{TagClusterID: testClusterID, TagRetained: "true"}
+	if diff := cmp.Diff(wantTags, tags); diff != "" {
+		t.Errorf("unexpected tags of the released ingress (-want +got):\n%s", diff)
+	}
+
+	// The garbage collector neither lists nor deletes the released ingress
+	loadBalancers, err := lb.ListLoadBalancers(context.Background(), "kubernetes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(loadBalancers) != 0 {
+		t.Errorf("expected the released ingress not to be listed, got %v", loadBalancers)
+	}
+	if err := lb.DeleteLoadBalancer(context.Background(), "kubernetes", name); err == nil {
+		t.Errorf("expected an error deleting the released ingress")
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected no deletion, got %v", deleted)
+	}
+}
+
+func TestLoadBalancerSharing(t *testing.T) {
+	newService := func(name string, created int, protocol v1.Protocol) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				UID:               types.UID(name + "-uid"),
+				CreationTimestamp: metav1.NewTime(time.Date(2024, 5, 1, created, 0, 0, 0, time.UTC)),
+				Annotations:       map[string]string{ServiceAnnotationLoadBalancerSharingKey: "dns"},
+			},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Name: "dns", Port: 53, TargetPort: intstrFromInt(53), Protocol: protocol, NodePort: 30053}},
+			},
+		}
+	}
+	tcp := newService("dns-tcp", 1, v1.ProtocolTCP)
+	udp := newService("dns-udp", 2, v1.ProtocolUDP)
+	clash := newService("dns-clash", 3, v1.ProtocolTCP)
+
+	// The fake mgmt API keeps the configuration of the shared ingress
+	var ingress *LoadBalancerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if ingress == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case "POST":
+			ingress = &LoadBalancerRequest{}
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "PATCH":
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "DELETE":
+			ingress = nil
+			return
+		}
+		config, _ := json.Marshal(ingress)
+		fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10", "ports": [{"port": 53, "protocol": "TCP"}, {"port": 53, "protocol": "UDP"}]}]}}`, config)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	provider.SetInformers(informerFactory)
+	indexer := informerFactory.Core().V1().Services().Informer().GetIndexer()
+	for _, service := range []*v1.Service{tcp, udp, clash} {
+		indexer.Add(service)
+	}
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	name := lb.GetLoadBalancerName(ctx, "kubernetes", tcp)
+	if other := lb.GetLoadBalancerName(ctx, "kubernetes", udp); other != name {
+		t.Fatalf("expected services with the same sharing key to share load balancer %s, got %s", name, other)
+	}
+
+	expectPorts := func(want ...string) {
+		t.Helper()
+		var got []string
+		if ingress != nil {
+			for _, port := range ingress.Ports {
+				got = append(got, fmt.Sprintf("%s %d/%s", port.Name, port.Port, port.Protocol))
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected ports (-want +got):\n%s", diff)
+		}
+	}
+	expectEvent := func(reason string) {
+		t.Helper()
+		select {
+		case event := <-recorder.Events:
+			if !strings.Contains(event, reason) {
+				t.Errorf("expected %s event, got %q", reason, event)
+			}
+		default:
+			t.Errorf("expected %s event, got none", reason)
+		}
+	}
+
+	status, err := lb.EnsureLoadBalancer(ctx, "kubernetes", udp, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectPorts("dns-tcp-dns 53/TCP", "dns-udp-dns 53/UDP")
+	if diff := cmp.Diff([]v1.PortStatus{{Port: 53, Protocol: v1.ProtocolUDP}}, status.Ingress[0].Ports); diff != "" {
+		t.Errorf("unexpected port status (-want +got):\n%s", diff)
+	}
+	if ingress.Tags[TagSharingKey] != "dns" || ingress.Tags[TagServiceUID] != "" {
+		t.Errorf("unexpected tags %v", ingress.Tags)
+	}
+
+	// The newest service cannot take a port of an older one
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", clash, nil); err == nil {
+		t.Errorf("expected an error for overlapping ports")
+	}
+	expectEvent(eventReasonLoadBalancerSharingConflict)
+	expectPorts("dns-tcp-dns 53/TCP", "dns-udp-dns 53/UDP")
+
+	// Deleting a member keeps the load balancer for the others, freeing its ports
+	indexer.Delete(tcp)
+	if err := lb.EnsureLoadBalancerDeleted(ctx, "kubernetes", tcp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectEvent(eventReasonSharedLoadBalancerKept)
+	expectPorts("dns-udp-dns 53/UDP", "dns-clash-dns 53/TCP")
+
+	indexer.Delete(udp)
+	if err := lb.EnsureLoadBalancerDeleted(ctx, "kubernetes", udp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectEvent(eventReasonSharedLoadBalancerKept)
+	expectPorts("dns-clash-dns 53/TCP")
+
+	// The last member deletes it
+	indexer.Delete(clash)
+	if err := lb.EnsureLoadBalancerDeleted(ctx, "kubernetes", clash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ingress != nil {
+		t.Errorf("expected the shared load balancer to be deleted")
+	}
+}
+
+func TestLoadBalancerSharingSettingsConflict(t *testing.T) {
+	newService := func(name string, created int, port int32, sourceRanges ...string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				UID:               types.UID(name + "-uid"),
+				CreationTimestamp: metav1.NewTime(time.Date(2024, 5, 1, created, 0, 0, 0, time.UTC)),
+				Annotations:       map[string]string{ServiceAnnotationLoadBalancerSharingKey: "web"},
+			},
+			Spec: v1.ServiceSpec{
+				Type:                     v1.ServiceTypeLoadBalancer,
+				Ports:                    []v1.ServicePort{{Name: "http", Port: port, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30000 + port}},
+				LoadBalancerSourceRanges: sourceRanges,
+			},
+		}
+	}
+	web := newService("web", 1, 80)
+	restricted := newService("restricted", 2, 81, "10.0.0.0/8")
+	metrics := newService("metrics", 3, 82)
+
+	var ingress *LoadBalancerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if ingress == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case "POST":
+			ingress = &LoadBalancerRequest{}
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "PATCH":
+			json.NewDecoder(r.Body).Decode(ingress)
+		}
+		config, _ := json.Marshal(ingress)
+		fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	provider.SetInformers(informerFactory)
+	indexer := informerFactory.Core().V1().Services().Informer().GetIndexer()
+	for _, service := range []*v1.Service{web, restricted, metrics} {
+		indexer.Add(service)
+	}
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	// A member with other settings than the oldest one is rejected
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", restricted, nil); err == nil {
+		t.Errorf("expected an error for differing source ranges")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonLoadBalancerSharingConflict) || !strings.Contains(event, "sourceRanges") {
+			t.Errorf("expected %s event about sourceRanges, got %q", eventReasonLoadBalancerSharingConflict, event)
+		}
+	default:
+		t.Errorf("expected %s event, got none", eventReasonLoadBalancerSharingConflict)
+	}
+
+	// and left out of the load balancer of the others
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", metrics, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ports []string
+	for _, port := range ingress.Ports {
+		ports = append(ports, port.Name)
+	}
+	if diff := cmp.Diff([]string{"web-http", "metrics-http"}, ports); diff != "" {
+		t.Errorf("unexpected ports (-want +got):\n%s", diff)
+	}
+	if len(ingress.SourceRanges) != 0 {
+		t.Errorf("expected the source ranges of the oldest member, got %v", ingress.SourceRanges)
+	}
+}
+
+func TestLoadBalancerSharingLock(t *testing.T) {
+	newService := func(namespace, sharingKey string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   namespace,
+				Annotations: map[string]string{ServiceAnnotationLoadBalancerSharingKey: sharingKey},
+			},
+		}
+	}
+
+	lb := &VCloudLoadBalancer{provider: createTestProvider(t)}
+	unlock := lb.lockSharing(newService("default", "web"))
+
+	// Other sharing keys, and the same key in other namespaces, are not blocked
+	for _, service := range []*v1.Service{newService("default", "api"), newService("other", "web")} {
+		done := make(chan struct{})
+		go func() {
+			lb.lockSharing(service)()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("lock of %s/%s blocked by default/web", service.Namespace, getSharingKey(service))
+		}
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		lb.lockSharing(newService("default", "web"))()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the lock of default/web to be held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock of default/web to be released")
+	}
+
+	lb.sharingMu.Lock()
+	defer lb.sharingMu.Unlock()
+	if len(lb.sharing) != 0 {
+		t.Errorf("expected the released locks to be dropped, got %d", len(lb.sharing))
+	}
+}
+
+func TestLoadBalancerTLS(t *testing.T) {
+	newSecret := func(commonName string) *v1.Secret {
+		certPEM, keyPEM := generateTestCertificate(t, commonName)
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-tls", Namespace: "default"},
+			Type:       v1.SecretTypeTLS,
+			Data:       map[string][]byte{v1.TLSCertKey: certPEM, v1.TLSPrivateKeyKey: keyPEM},
+		}
+	}
+	https := "https"
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       types.UID("abc123-def456"),
+			Annotations: map[string]string{
+				ServiceAnnotationLoadBalancerTLSCertificates: "web-tls,vcloud:cert-wildcard",
+				ServiceAnnotationLoadBalancerTLSPorts:        "https",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080},
+				{Name: "https", Port: 443, TargetPort: intstrFromInt(8443), Protocol: v1.ProtocolTCP, NodePort: 30443, AppProtocol: &https},
+			},
+		},
+	}
+
+	// The fake mgmt API stores the uploaded certificate and the last ingress request
+	var certificate *Certificate
+	var ingress *LoadBalancerRequest
+	var certificateWrites []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/certificates") {
+			if r.Method != "GET" {
+				certificateWrites = append(certificateWrites, r.Method)
+				certificate = &Certificate{}
+				json.NewDecoder(r.Body).Decode(certificate)
+				block, _ := pem.Decode([]byte(certificate.Certificate))
+				sum := sha256.Sum256(block.Bytes)
+				certificate.ID = fmt.Sprintf("cert-%d", len(certificateWrites))
+				certificate.Fingerprint = hex.EncodeToString(sum[:])
+				certificate.Certificate, certificate.PrivateKey = "", ""
+			}
+			if certificate == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(CertificateResponse{Status: 200, Data: *certificate})
+			return
+		}
+		switch r.Method {
+		case "GET":
+			if ingress == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case "POST":
+			ingress = &LoadBalancerRequest{}
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "PATCH":
+			json.NewDecoder(r.Body).Decode(ingress)
+		}
+		config, _ := json.Marshal(ingress)
+		fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	kubeClient := fake.NewSimpleClientset(service)
+	provider.kubeClient = kubeClient
+	stop := make(chan struct{})
+	defer close(stop)
+	provider.stop = stop
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	provider.SetInformers(informerFactory)
+	informerFactory.Core().V1().Services().Informer().GetIndexer().Add(service)
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	expectEvent := func(reason string) {
+		t.Helper()
+		select {
+		case event := <-recorder.Events:
+			if !strings.Contains(event, reason) {
+				t.Errorf("expected %s event, got %q", reason, event)
+			}
+		default:
+			t.Errorf("expected %s event, got none", reason)
+		}
+	}
+	expectTLS := func(certificateID string) {
+		t.Helper()
+		want := []*LoadBalancerPortTLS{nil, {Certificates: []string{certificateID, "cert-wildcard"}, BackendProtocol: BackendProtocolHTTPS}}
+		var got []*LoadBalancerPortTLS
+		for _, port := range ingress.Ports {
+			got = append(got, port.TLS)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected port TLS (-want +got):\n%s", diff)
+		}
+	}
+
+	// Secrets are only watched once a service references one, and only kubernetes.io/tls Secrets
+	if provider.secretsInformer != nil {
+		t.Fatalf("expected no secret informer before a service uses TLS")
+	}
+
+	// A missing secret fails the sync
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	}
+	expectEvent(eventReasonInvalidTLSCertificate)
+
+	var selectors []string
+	for _, action := range kubeClient.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "secrets" {
+			selectors = append(selectors, list.GetListRestrictions().Fields.String())
+		}
+	}
+	if diff := cmp.Diff([]string{"type=kubernetes.io/tls"}, selectors); diff != "" {
+		t.Errorf("unexpected secret lists (-want +got):\n%s", diff)
+	}
+	secrets := provider.secretsInformer.GetIndexer()
+
+	secret := newSecret("web.example.com")
+	secrets.Add(secret)
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectEvent(eventReasonTLSCertificateUploaded)
+	expectTLS("cert-1")
+	if want := buildCertificateName("test-cluster", "default", "web-tls"); certificate.Name != want || certificate.Tags[TagSecretName] != "web-tls" {
+		t.Errorf("expected certificate %s uploaded from web-tls, got %+v", want, certificate)
+	}
+
+	// An unchanged secret is not uploaded again
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"POST"}, certificateWrites); diff != "" {
+		t.Errorf("unexpected certificate writes (-want +got):\n%s", diff)
+	}
+
+	// A new certificate in the secret requeues the service and rotates the certificate
+	rotated := newSecret("www.example.com")
+	rotated.ResourceVersion = "2"
+	var requeued []string
+	provider.AddServiceRequeueFunc(func(service *v1.Service) { requeued = append(requeued, service.Name) })
+	if err := provider.requeueSecretServices(rotated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{service.Name}, requeued); diff != "" {
+		t.Errorf("unexpected requeued services (-want +got):\n%s", diff)
+	}
+
+	secrets.Update(rotated)
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectEvent(eventReasonTLSCertificateRotated)
+	expectTLS("cert-2")
+	if diff := cmp.Diff([]string{"POST", "PUT"}, certificateWrites); diff != "" {
+		t.Errorf("unexpected certificate writes (-want +got):\n%s", diff)
+	}
+}
+
+func TestCertificateCleanupConditions(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "https", Port: 443, TargetPort: intstrFromInt(8443), Protocol: v1.ProtocolTCP, NodePort: 30443}},
+		},
+	}
+
+	// The fake mgmt API keeps the ingress and fails to list certificates
+	var ingress *LoadBalancerRequest
+	var certificateRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/certificates") {
+			certificateRequests = append(certificateRequests, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			if ingress == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case "POST":
+			ingress = &LoadBalancerRequest{}
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "PATCH":
+			json.NewDecoder(r.Body).Decode(ingress)
+		case "DELETE":
+			ingress = nil
+			return
+		}
+		config, _ := json.Marshal(ingress)
+		fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	provider.SetInformers(informerFactory)
+	informerFactory.Core().V1().Services().Informer().GetIndexer().Add(service)
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	// Services that never terminated TLS leave the certificates alone
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lb.EnsureLoadBalancerDeleted(ctx, "kubernetes", service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(certificateRequests) != 0 {
+		t.Errorf("expected no certificate requests, got %v", certificateRequests)
+	}
+
+	// A service that stopped terminating TLS cleans up, a failure being reported without failing the sync
+	ingress = &LoadBalancerRequest{
+		Name:  lb.GetLoadBalancerName(ctx, "kubernetes", service),
+		Ports: []LoadBalancerPort{{Name: "web-https", Port: 443, TargetPort: "30443", Protocol: "TCP", TLS: &LoadBalancerPortTLS{Certificates: []string{"cert-1"}}}},
+	}
+	if _, err := lb.EnsureLoadBalancer(ctx, "kubernetes", service, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"GET /clusters/" + testClusterID + "/certificates"}, certificateRequests); diff != "" {
+		t.Errorf("unexpected certificate requests (-want +got):\n%s", diff)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonTLSCertificateCleanupFailed) {
+			t.Errorf("expected %s event, got %q", eventReasonTLSCertificateCleanupFailed, event)
+		}
+	default:
+		t.Errorf("expected %s event, got none", eventReasonTLSCertificateCleanupFailed)
+	}
+}
+
+func TestRemoveUnusedCertificates(t *testing.T) {
+	newService := func(name, secrets string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				UID:         types.UID(name + "-uid"),
+				Annotations: map[string]string{ServiceAnnotationLoadBalancerTLSCertificates: secrets},
+			},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		}
+	}
+	web := newService("web", "web-tls")
+	www := newService("www", "web-tls,vcloud:cert-wildcard")
+	api := newService("api", "api-tls")
+	blog := newService("blog", "blog-tls")
+
+	// The fake mgmt API stores the certificates of the namespace, another namespace and another cluster
+	certificates := make(map[string]*Certificate)
+	addCertificate := func(clusterID, namespace, secretName string) string {
+		name := buildCertificateName("test-cluster", namespace, secretName)
+		if clusterID != testClusterID {
+			name = "other-" + name
+		}
+		certificates[name] = &Certificate{
+			ID:   "cert-" + secretName,
+			Name: name,
+			Tags: map[string]string{TagClusterID: clusterID, TagServiceNamespace: namespace, TagSecretName: secretName},
+		}
+		return name
+	}
+	webCert := addCertificate(testClusterID, "default", "web-tls")
+	apiCert := addCertificate(testClusterID, "default", "api-tls")
+	blogCert := addCertificate(testClusterID, "default", "blog-tls")
+	addCertificate(testClusterID, "kube-system", "api-tls")
+	addCertificate("0b6c1a7e-3f42-4c1e-9d0a-2f4b8e5c6d71", "default", "old-tls")
+
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/certificates")
+		name = strings.TrimPrefix(name, "/")
+		switch r.Method {
+		case "GET":
+			var list CertificateListResponse
+			for _, certificate := range certificates {
+				list.Data.Certificates = append(list.Data.Certificates, *certificate)
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		case "DELETE":
+			deleted = append(deleted, name)
+			delete(certificates, name)
+			return
+		case "PATCH":
+			var patch Certificate
+			json.NewDecoder(r.Body).Decode(&patch)
+			for key, value := range patch.Tags {
+				certificates[name].Tags[key] = value
+			}
+		}
+		json.NewEncoder(w).Encode(CertificateResponse{Status: 200, Data: *certificates[name]})
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+	ctx := context.Background()
+
+	// Nothing is deleted without the service informer to tell the references
+	if err := lb.removeUnusedCertificates(ctx, web, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected no deletion without the service informer, got %v", deleted)
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	provider.SetInformers(informerFactory)
+	indexer := informerFactory.Core().V1().Services().Informer().GetIndexer()
+	for _, service := range []*v1.Service{web, www, api, blog} {
+		indexer.Add(service)
+	}
+
+	expectDeleted := func(want ...string) {
+		t.Helper()
+		if diff := cmp.Diff(want, deleted); diff != "" {
+			t.Errorf("unexpected deleted certificates (-want +got):\n%s", diff)
+		}
+		deleted = nil
+		for range want {
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, eventReasonTLSCertificateDeleted) {
+					t.Errorf("expected %s event, got %q", eventReasonTLSCertificateDeleted, event)
+				}
+			default:
+				t.Errorf("expected %s event, got none", eventReasonTLSCertificateDeleted)
+			}
+		}
+	}
+
+	// Removing the annotation deletes the certificate, the synced service taking precedence over the informer
+	unannotated := api.DeepCopy()
+	unannotated.Annotations = nil
+	if err := lb.removeUnusedCertificates(ctx, unannotated, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted(apiCert)
+
+	// A deleted service leaves the certificates of other services and the Secrets they still use
+	if err := lb.removeUnusedCertificates(ctx, blog, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted(blogCert)
+	if err := lb.removeUnusedCertificates(ctx, www, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted()
+
+	// The certificates of a retained load balancer are kept
+	indexer.Delete(www)
+	if err := lb.retainCertificates(ctx, web); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if certificates[webCert].Tags[TagRetained] != "true" {
+		t.Errorf("expected certificate %s to be retained, got tags %v", webCert, certificates[webCert].Tags)
+	}
+	if err := lb.removeUnusedCertificates(ctx, web, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted()
+	if len(certificates) != 3 {
+		t.Errorf("expected the retained certificate and those of other namespaces and clusters to be kept, got %v", certificates)
+	}
+}
+
+func TestLoadBalancerNodeSelector(t *testing.T) {
+	newNode := func(name, address string, nodeLabels map[string]string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}}},
+		}
+	}
+	ingressNode := newNode("ingress-1", "10.0.1.100", map[string]string{"pool": "ingress"})
+	workerNode := newNode("worker-1", "10.0.1.101", map[string]string{"pool": "workers"})
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerNodeSelector: "pool=ingress"},
+		},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+
+	provider := createTestProvider(t)
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+	req, err := lb.buildLoadBalancerRequest("test-lb", service, []*v1.Node{ingressNode, workerNode})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"10.0.1.100"}, req.Nodes); diff != "" {
+		t.Errorf("unexpected nodes (-want +got):\n%s", diff)
+	}
+
+	// An empty selection fails instead of removing all backends
+	if _, err := lb.buildLoadBalancerRequest("test-lb", service, []*v1.Node{workerNode}); err == nil {
+		t.Errorf("expected an error when no node matches the selector")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonNoNodesSelected) {
+			t.Errorf("expected %s event, got %q", eventReasonNoNodesSelected, event)
+		}
+	default:
+		t.Errorf("expected %s event, got none", eventReasonNoNodesSelected)
+	}
+
+	// Relabeling a node requeues the services it starts or stops matching
+	other := service.DeepCopy()
+	other.Name = "api"
+	other.Annotations = map[string]string{ServiceAnnotationLoadBalancerNodeSelector: "zone=a"}
+	kubeClient := fake.NewSimpleClientset(service, other)
+	provider.kubeClient = kubeClient
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	provider.SetInformers(informerFactory)
+	informerFactory.Core().V1().Services().Informer().GetIndexer().Add(service)
+	informerFactory.Core().V1().Services().Informer().GetIndexer().Add(other)
+
+	relabeled := workerNode.DeepCopy()
+	relabeled.Labels["pool"] = "ingress"
+	var requeued []string
+	provider.AddServiceRequeueFunc(func(service *v1.Service) { requeued = append(requeued, service.Name) })
+	if err := provider.requeueNodeSelectorServices(workerNode, relabeled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"web"}, requeued); diff != "" {
+		t.Errorf("unexpected requeued services (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveFrontendNetwork(t *testing.T) {
+	provider := createTestProvider(t)
+	provider.externalNetwork = frontendNetwork{Network: "public", Subnet: "public-a"}
+	provider.internalNetwork = frontendNetwork{Network: "vpc", Subnet: "vpc-a"}
+
+	tests := []struct {
+		name        string
+		annotations *serviceAnnotations
+		want        frontendNetwork
+	}{
+		{
+			name:        "external default",
+			annotations: &serviceAnnotations{},
+			want:        frontendNetwork{Network: "public", Subnet: "public-a"},
+		},
+		{
+			name:        "internal default",
+			annotations: &serviceAnnotations{Internal: true},
+			want:        frontendNetwork{Network: "vpc", Subnet: "vpc-a"},
+		},
+		{
+			name:        "subnet of the default network",
+			annotations: &serviceAnnotations{Internal: true, Subnet: "vpc-b"},
+			want:        frontendNetwork{Network: "vpc", Subnet: "vpc-b"},
+		},
+		{
+			name:        "other network drops the default subnet",
+			annotations: &serviceAnnotations{Network: "dmz"},
+			want:        frontendNetwork{Network: "dmz"},
+		},
+		{
+			name:        "other network and subnet",
+			annotations: &serviceAnnotations{Network: "dmz", Subnet: "dmz-a"},
+			want:        frontendNetwork{Network: "dmz", Subnet: "dmz-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, provider.resolveFrontendNetwork(tt.annotations)); diff != "" {
+				t.Errorf("unexpected network (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerRecreation(t *testing.T) {
+	provider := createTestProvider(t)
+	provider.internalNetwork = frontendNetwork{Network: "vpc"}
+	lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+	newService := func(annotations map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456"), Annotations: annotations},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstrFromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}},
+			},
+		}
+	}
+	external, err := lb.buildLoadBalancerRequest(lb.GetLoadBalancerName(context.Background(), "kubernetes", newService(nil)), newService(nil), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unrecorded := *external
+	unrecorded.Network = ""
+	onNetwork := *external
+	onNetwork.Network, onNetwork.Subnet = "dmz", "dmz-a"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		current     *LoadBalancerRequest
+		wantMethods []string
+		wantEvents  []string
+		wantErr     bool
+	}{
+		{
+			name:        "external to internal",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerInternal: "true"},
+			current:     external,
+			wantMethods: []string{"GET", "DELETE", "POST"},
+			wantEvents: []string{
+				"Warning LoadBalancerRecreating Recreating load balancer " + external.Name + ` to change its frontend (internal false -> true), its IP will change`,
+				"Normal LoadBalancerRecreated Recreated load balancer " + external.Name + ` with its new frontend (internal false -> true)`,
+			},
+		},
+		{
+			name:        "network changed keeping IPs",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNetwork: "dmz", ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetainIP},
+			current:     &LoadBalancerRequest{Name: external.Name, Network: "public"},
+			wantMethods: []string{"GET", "DELETE", "POST"},
+			wantEvents: []string{
+				"Warning LoadBalancerRecreating Recreating load balancer " + external.Name + ` to change its frontend (network "public" -> "dmz"), its IP will change`,
+				"Normal LoadBalancerIPRetained Deleted load balancer " + external.Name + ", its public IPs 203.0.113.10 are kept reserved",
+				"Normal LoadBalancerRecreated Recreated load balancer " + external.Name + ` with its new frontend (network "public" -> "dmz")`,
+			},
+		},
+		{
+			name:        "network not recorded",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNetwork: "dmz"},
+			current:     &unrecorded,
+			wantMethods: []string{"GET"},
+		},
+		{
+			name:        "network annotation removed",
+			annotations: nil,
+			current:     &onNetwork,
+			wantMethods: []string{"GET"},
+		},
+		{
+			name: "deletion protected",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInternal:           "true",
+				ServiceAnnotationLoadBalancerDeletionProtection: "true",
+			},
+			current:     external,
+			wantMethods: []string{"GET"},
+			wantEvents: []string{
+				"Warning LoadBalancerDeletionProtected Load balancer " + external.Name + ` must be recreated to change its frontend (internal false -> true), but it is protected from deletion, remove the ` +
+					ServiceAnnotationLoadBalancerDeletionProtection + " annotation to recreate it",
+			},
+			wantErr: true,
+		},
+		{
+			name: "retained",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInternal:     "true",
+				ServiceAnnotationLoadBalancerRetainPolicy: RetainPolicyRetain,
+			},
+			current:     external,
+			wantMethods: []string{"GET"},
+			wantEvents: []string{
+				"Warning LoadBalancerRecreationBlocked Load balancer " + external.Name + ` must be recreated to change its frontend (internal false -> true), but the Retain policy keeps the ingress`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var methods []string
+			var deleteQuery string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				if r.Method == "DELETE" {
+					deleteQuery = r.URL.RawQuery
+					return
+				}
+				config, _ := json.Marshal(tt.current)
+				fmt.Fprintf(w, `{"status": 200, "data": {"config": %s, "ingress": [{"ip": "203.0.113.10"}]}}`, config)
+			}))
+			defer server.Close()
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+
+			_, err := lb.EnsureLoadBalancer(context.Background(), "kubernetes", newService(tt.annotations), nil)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.wantMethods, methods); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+			wantQuery := ""
+			if tt.annotations[ServiceAnnotationLoadBalancerRetainPolicy] == RetainPolicyRetainIP {
+				wantQuery = "retainPublicIPs=true"
+			}
+			if deleteQuery != wantQuery {
+				t.Errorf("expected delete query %q, got %q", wantQuery, deleteQuery)
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if diff := cmp.Diff(tt.wantEvents, events); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInstanceClusterMembership(t *testing.T) {
+	const otherClusterID = "0b6c1a7e-3f42-4c1e-9d0a-2f4b8e5c6d71"
+
+	tests := []struct {
+		name         string
+		policy       string
+		clusterID    string
+		status       string
+		owned        bool
+		wantExists   bool
+		wantExistErr bool
+		wantMetaErr  error
+	}{
+		{
+			name:       "own cluster",
+			policy:     ForeignInstancePolicyError,
+			clusterID:  testClusterID,
+			status:     "active",
+			owned:      true,
+			wantExists: true,
+		},
+		{
+			name:         "foreign instance with error policy",
+			policy:       ForeignInstancePolicyError,
+			clusterID:    otherClusterID,
+			status:       "active",
+			owned:        true,
+			wantExistErr: true,
+			wantMetaErr:  errForeignInstance,
+		},
+		{
+			name:        "foreign instance with notfound policy",
+			policy:      ForeignInstancePolicyNotFound,
+			clusterID:   otherClusterID,
+			status:      "active",
+			owned:       true,
+			wantExists:  false,
+			wantMetaErr: cloudprovider.InstanceNotFound,
+		},
+		{
+			name:         "unowned foreign instance with notfound policy",
+			policy:       ForeignInstancePolicyNotFound,
+			clusterID:    otherClusterID,
+			status:       "active",
+			owned:        false,
+			wantExistErr: true,
+			wantMetaErr:  errForeignInstance,
+		},
+		{
+			name:         "unowned foreign instance with error policy",
+			policy:       ForeignInstancePolicyError,
+			clusterID:    otherClusterID,
+			status:       "active",
+			owned:        false,
+			wantExistErr: true,
+			wantMetaErr:  errForeignInstance,
+		},
+		{
+			name:        "unowned terminated instance",
+			policy:      ForeignInstancePolicyError,
+			clusterID:   testClusterID,
+			status:      "terminated",
+			owned:       false,
+			wantExists:  true,
+			wantMetaErr: cloudprovider.InstanceNotFound,
+		},
+		{
+			name:        "owned terminated instance",
+			policy:      ForeignInstancePolicyError,
+			clusterID:   testClusterID,
+			status:      "terminated",
+			owned:       true,
+			wantExists:  false,
+			wantMetaErr: cloudprovider.InstanceNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"status": 200, "data": {"instance": {"id": "instance-1", "status": %q, "state": "POWERED_ON", "owned": %t, "metadata": {"cluster": {"id": %q}}}}}`,
+					tt.status, tt.owned, tt.clusterID)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			provider.foreignInstancePolicy = tt.policy
+			instances := NewVCloudInstances(provider)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+			}
+
+			exists, err := instances.InstanceExists(context.Background(), node)
+			if tt.wantExistErr != (err != nil) {
+				t.Errorf("InstanceExists: expected error=%t, got %v", tt.wantExistErr, err)
+			}
+			if exists != tt.wantExists {
+				t.Errorf("InstanceExists: expected exists=%t, got %t", tt.wantExists, exists)
+			}
+
+			_, err = instances.InstanceMetadata(context.Background(), node)
+			if !errors.Is(err, tt.wantMetaErr) {
+				t.Errorf("InstanceMetadata: expected error %v, got %v", tt.wantMetaErr, err)
+			}
+
+			// InstanceShutdown reports foreign and missing instances like InstanceMetadata
+			_, err = instances.InstanceShutdown(context.Background(), node)
+			if !errors.Is(err, tt.wantMetaErr) {
+				t.Errorf("InstanceShutdown: expected error %v, got %v", tt.wantMetaErr, err)
+			}
+		})
+	}
+}
+
+func TestInstanceCacheStaleOnError(t *testing.T) {
+	tests := []struct {
+		name         string
+		staleOnError bool
+		cached       *InstanceInfo
+		age          time.Duration
+		wantExists   bool
+		wantErr      bool
+	}{
+		{
+			name:         "stale entry served for existing instance",
+			staleOnError: true,
+			cached:       &InstanceInfo{Exists: true},
+			age:          time.Minute,
+			wantExists:   true,
+		},
+		{
+			name:         "stale-on-error disabled",
+			staleOnError: false,
+			cached:       &InstanceInfo{Exists: true},
+			age:          time.Minute,
+			wantErr:      true,
+		},
+		{
+			name:         "entry older than max staleness",
+			staleOnError: true,
+			cached:       &InstanceInfo{Exists: true},
+			age:          time.Hour,
+			wantErr:      true,
+		},
+		{
+			name:         "stale non-existent instance is never reported as missing",
+			staleOnError: true,
+			cached:       &InstanceInfo{Exists: false},
+			age:          time.Minute,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			provider.cacheStaleOnError = tt.staleOnError
+			provider.cacheMaxStaleness = 5 * time.Minute
+			instances := NewVCloudInstances(provider).(*VCloudInstances)
+			instances.cache.cache["instance-1"] = &cacheEntry{
+				info:      tt.cached,
+				timestamp: time.Now().Add(-tt.age),
+				ttl:       instanceCacheTTL,
+			}
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+			}
+
+			exists, err := instances.InstanceExists(context.Background(), node)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("expected error=%t, got %v", tt.wantErr, err)
+			}
+			if exists != tt.wantExists {
+				t.Errorf("expected exists=%t, got %t", tt.wantExists, exists)
+			}
+		})
+	}
+}
+
+func TestNotificationHandler(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+	}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		token        string
+		body         string
+		wantStatus   int
+		wantRequeued string
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			token:      "callback-token",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "invalid token",
+			method:     http.MethodPost,
+			token:      "wrong-token",
+			body:       `{"kind": "instance", "id": "instance-1"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown kind",
+			method:     http.MethodPost,
+			token:      "callback-token",
+			body:       `{"kind": "volume", "id": "volume-1"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "instance notification requeues node",
+			method:       http.MethodPost,
+			token:        "callback-token",
+			body:         `{"kind": "instance", "id": "instance-1"}`,
+			wantStatus:   http.StatusAccepted,
+			wantRequeued: "nodes",
+		},
+		{
+			name:         "ingress notification requeues service",
+			method:       http.MethodPost,
+			token:        "callback-token",
+			body:         `{"kind": "ingress", "name": "test-cluster-ingress-abc123-web"}`,
+			wantStatus:   http.StatusAccepted,
+			wantRequeued: "services",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := createTestProvider(t)
+			provider.callbackToken = "callback-token"
+
+			kubeClient := fake.NewSimpleClientset(node, service)
+			informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+			provider.SetInformers(informerFactory)
+			informerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(node)
+			informerFactory.Core().V1().Services().Informer().GetIndexer().Add(service)
+			var requeued string
+			provider.AddNodeRequeueFunc(func(*v1.Node) { requeued = "nodes" })
+			provider.AddServiceRequeueFunc(func(*v1.Service) { requeued = "services" })
+
+			instances := provider.instances.(*VCloudInstances)
+			instances.cache.cache["instance-1"] = &cacheEntry{info: &InstanceInfo{Exists: true}, timestamp: time.Now(), ttl: instanceCacheTTL}
+
+			handler := provider.HTTPHandlers()[notificationPath]
+			req := httptest.NewRequest(tt.method, notificationPath, strings.NewReader(tt.body))
+			req.Header.Set(callbackTokenHeader, tt.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if requeued != tt.wantRequeued {
+				t.Errorf("expected requeued resource %q, got %q", tt.wantRequeued, requeued)
+			}
+
+			_, cached := instances.cache.cache["instance-1"]
+			if wantCached := tt.wantRequeued != "nodes"; cached != wantCached {
+				t.Errorf("expected cached=%t, got %t", wantCached, cached)
+			}
+		})
+	}
+}
+
+func TestInstanceWatcher(t *testing.T) {
+	var requestedVersions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" || r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rv := r.URL.Query().Get("resourceVersion")
+		requestedVersions = append(requestedVersions, rv)
+		if rv != "" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		instance := `{"instance": {"id": "instance-1", "status": "active", "state": %q, "owned": true, "metadata": {"cluster": {"id": %q}}}}`
+		fmt.Fprintf(w, ": keep-alive\n\n")
+		fmt.Fprintf(w, "id: 1\nevent: ADDED\ndata: "+instance+"\n\n", "POWERED_ON", testClusterID)
+		fmt.Fprintf(w, "id: 2\nevent: MODIFIED\ndata: "+instance+"\n\n", "POWERED_OFF", testClusterID)
+		fmt.Fprintf(w, "id: 3\nevent: BOOKMARK\ndata: {}\n\n")
+	}))
+	defer server.Close()
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+	}
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	kubeClient := fake.NewSimpleClientset(node)
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	provider.SetInformers(informerFactory)
+	informerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(node)
+	requeues := 0
+	provider.AddNodeRequeueFunc(func(*v1.Node) { requeues++ })
+
+	instances := provider.instances.(*VCloudInstances)
+	watcher := newInstanceWatcher(provider, instances.cache)
+
+	if err := watcher.watch(context.Background()); err != nil {
+		t.Fatalf("unexpected watch error: %v", err)
+	}
+
+	if watcher.resourceVersion != "3" {
+		t.Errorf("expected resource version %q, got %q", "3", watcher.resourceVersion)
+	}
+
+	entry, ok := instances.cache.cache["instance-1"]
+	if !ok {
+		t.Fatal("expected instance-1 to be cached")
+	}
+	if !entry.info.Exists || !entry.info.Shutdown {
+		t.Errorf("expected cached instance to exist and be shutdown, got exists=%t shutdown=%t", entry.info.Exists, entry.info.Shutdown)
+	}
+
+	if requeues != 1 {
+		t.Errorf("expected node to be requeued once, got %d requeues", requeues)
+	}
+
+	// Resuming from an expired resource version restarts the watch
+	if err := watcher.watch(context.Background()); err != nil {
+		t.Fatalf("unexpected watch error: %v", err)
+	}
+	if watcher.resourceVersion != "" {
+		t.Errorf("expected resource version to be reset, got %q", watcher.resourceVersion)
+	}
+	if len(watcher.states) != 0 || watcher.synced {
+		t.Errorf("expected the known instances to be reset, got %v (synced=%t)", watcher.states, watcher.synced)
+	}
+	if len(requestedVersions) != 2 || requestedVersions[1] != "3" {
+		t.Errorf("expected watch to resume from version 3, got %v", requestedVersions)
+	}
+}
+
+func TestInstanceWatcherRequeue(t *testing.T) {
+	const instance = `{"instance": {"id": "instance-1", "status": "active", "state": "POWERED_ON", "owned": true, "metadata": {"cluster": {"id": "` + testClusterID + `"}}}}`
+
+	tests := []struct {
+		name         string
+		events       string
+		wantRequeues int
+	}{
+		{
+			name:         "initial events",
+			events:       "id: 1\nevent: ADDED\ndata: " + instance + "\n\nid: 2\nevent: BOOKMARK\ndata: {}\n\n",
+			wantRequeues: 0,
+		},
+		{
+			name:         "instance appears",
+			events:       "id: 1\nevent: BOOKMARK\ndata: {}\n\nid: 2\nevent: ADDED\ndata: " + instance + "\n\n",
+			wantRequeues: 1,
+		},
+		{
+			name: "instance comes back after deletion",
+			events: "id: 1\nevent: ADDED\ndata: " + instance + "\n\nid: 2\nevent: BOOKMARK\ndata: {}\n\n" +
+				"id: 3\nevent: DELETED\ndata: " + instance + "\n\nid: 4\nevent: ADDED\ndata: " + instance + "\n\n",
+			wantRequeues: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.events)
+			}))
+			defer server.Close()
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+			}
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			kubeClient := fake.NewSimpleClientset(node)
+			informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+			provider.SetInformers(informerFactory)
+			informerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(node)
+			requeues := 0
+			provider.AddNodeRequeueFunc(func(*v1.Node) { requeues++ })
+
+			watcher := newInstanceWatcher(provider, provider.instances.(*VCloudInstances).cache)
+			if err := watcher.watch(context.Background()); err != nil {
+				t.Fatalf("unexpected watch error: %v", err)
+			}
+
+			if requeues != tt.wantRequeues {
+				t.Errorf("expected %d node requeues, got %d", tt.wantRequeues, requeues)
+			}
+		})
+	}
+}
+
+func TestInstanceWatcherIdleTimeout(t *testing.T) {
+	const instance = `{"instance": {"id": "instance-1", "status": "active", "state": "POWERED_ON", "owned": true, "metadata": {"cluster": {"id": "` + testClusterID + `"}}}}`
+
+	// The fake mgmt API sends the events, then stalls without closing the stream
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "id: 1\nevent: ADDED\ndata: "+instance+"\n\nid: 2\nevent: BOOKMARK\ndata: {}\n\n")
+		fmt.Fprint(w, "id: 3\nevent: DELETED\ndata: "+instance+"\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	watcher := newInstanceWatcher(provider, provider.instances.(*VCloudInstances).cache)
+	watcher.idleTimeout = 100 * time.Millisecond
+
+	err := watcher.watch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no event or keep-alive") {
+		t.Fatalf("expected an idle timeout error, got %v", err)
+	}
+	if watcher.resourceVersion != "3" {
+		t.Errorf("expected resource version %q, got %q", "3", watcher.resourceVersion)
+	}
+	// Deleted instances are forgotten
+	if len(watcher.states) != 0 {
+		t.Errorf("expected no instance states, got %v", watcher.states)
+	}
+}
+
+func TestProviderEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantReason string
+	}{
+		{
+			name:       "quota exceeded",
+			status:     http.StatusUnprocessableEntity,
+			body:       `{"error": "public IP quota exceeded"}`,
+			wantReason: eventReasonQuotaExceeded,
+		},
+		{
+			name:       "auth failure",
+			status:     http.StatusUnauthorized,
+			body:       `{"error": "invalid token"}`,
+			wantReason: eventReasonAuthenticationFailed,
+		},
+		{
+			name:   "other client error",
+			status: http.StatusBadRequest,
+			body:   `{"error": "bad request"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("abc123-def456")},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			}
+			_, err := provider.loadbalancer.EnsureLoadBalancer(context.Background(), "kubernetes", service, nil)
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("expected APIError with status %d, got %v", tt.status, err)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("expected event with reason %q, got %q", tt.wantReason, event)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("expected event with reason %q, got none", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestForeignInstanceEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": 200, "data": {"instance": {"id": "instance-1", "status": "active", "owned": true, "metadata": {"cluster": {"id": "0b6c1a7e-3f42-4c1e-9d0a-2f4b8e5c6d71"}}}}}`)
+	}))
+	defer server.Close()
+
+	provider := createTestProvider(t)
+	provider.mgmtURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	provider.recorder = recorder
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       v1.NodeSpec{ProviderID: "vcloud://instance-1"},
+	}
+	if _, err := provider.instances.InstanceMetadata(context.Background(), node); err == nil {
+		t.Fatal("expected error for foreign instance")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonForeignInstance) {
+			t.Errorf("expected %s event, got %q", eventReasonForeignInstance, event)
+		}
+	default:
+		t.Errorf("expected %s event, got none", eventReasonForeignInstance)
+	}
+}
+
+func TestParseServiceAnnotations(t *testing.T) {
+	int32Ptr := func(v int32) *int32 { return &v }
+
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		loadBalancerIP string
+		want           *serviceAnnotations
+		wantErrs       []string
+	}{
+		{
+			name: "no annotations",
+			want: &serviceAnnotations{},
+		},
+		{
+			name: "all annotations",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInternal:                      "true",
+				ServiceAnnotationLoadBalancerAlgorithm:                     "Least-Connections",
+				ServiceAnnotationLoadBalancerIdleTimeout:                   "120",
+				ServiceAnnotationLoadBalancerConnectionDrainingTimeout:     "0",
+				ServiceAnnotationLoadBalancerProxyProtocol:                 "true",
+				ServiceAnnotationLoadBalancerHealthCheckPath:               "/ready",
+				ServiceAnnotationLoadBalancerHealthCheckInterval:           "10",
+				ServiceAnnotationLoadBalancerHealthCheckTimeout:            "5",
+				ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   "2",
+				ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold: "3",
+			},
+			want: &serviceAnnotations{
+				Internal:                  true,
+				Algorithm:                 AlgorithmLeastConnections,
+				IdleTimeout:               120,
+				ConnectionDrainingTimeout: int32Ptr(0),
+				ProxyProtocol:             true,
+				HealthCheck: &LoadBalancerHealthCheck{
+					Protocol:           HealthCheckProtocolHTTP,
+					Path:               "/ready",
+					Interval:           10,
+					Timeout:            5,
+					HealthyThreshold:   2,
+					UnhealthyThreshold: 3,
+				},
+			},
+		},
+		{
+			name: "invalid values are all reported",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInternal:            "yes",
+				ServiceAnnotationLoadBalancerAlgorithm:           "random",
+				ServiceAnnotationLoadBalancerIdleTimeout:         "0",
+				ServiceAnnotationLoadBalancerHealthCheckProtocol: "tcp",
+				ServiceAnnotationLoadBalancerHealthCheckPath:     "/healthz",
+			},
+			wantErrs: []string{
+				ServiceAnnotationLoadBalancerInternal,
+				ServiceAnnotationLoadBalancerAlgorithm,
+				ServiceAnnotationLoadBalancerIdleTimeout,
+				ServiceAnnotationLoadBalancerHealthCheckPath,
+			},
+		},
+		{
+			name: "health check timeout greater than interval",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerHealthCheckInterval: "5",
+				ServiceAnnotationLoadBalancerHealthCheckTimeout:  "10",
+			},
+			wantErrs: []string{ServiceAnnotationLoadBalancerHealthCheckTimeout},
+		},
+		{
+			name:           "spec load balancer IP",
+			loadBalancerIP: "203.0.113.20",
+			want:           &serviceAnnotations{LoadBalancerIP: "203.0.113.20"},
+		},
+		{
+			name:        "reserved IP",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerReservedIP: "web-ip"},
+			want:        &serviceAnnotations{ReservedIP: "web-ip"},
+		},
+		{
+			name:           "IP annotation conflicts with spec",
+			annotations:    map[string]string{ServiceAnnotationLoadBalancerIP: "203.0.113.20"},
+			loadBalancerIP: "203.0.113.21",
+			wantErrs:       []string{ServiceAnnotationLoadBalancerIP},
+		},
+		{
+			name: "invalid and combined IPs",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIP:         "203.0.113.300",
+				ServiceAnnotationLoadBalancerReservedIP: "Web_IP",
+			},
+			loadBalancerIP: "203.0.113.20",
+			wantErrs:       []string{ServiceAnnotationLoadBalancerIP, ServiceAnnotationLoadBalancerReservedIP},
+		},
+		{
+			name: "adopted ingress",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAdopt:        "legacy-web",
+				ServiceAnnotationLoadBalancerRetainPolicy: "retain",
+			},
+			want: &serviceAnnotations{AdoptIngress: "legacy-web", RetainPolicy: RetainPolicyRetain},
+		},
+		{
+			name: "deletion options",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerRetainPolicy:       "retainip",
+				ServiceAnnotationLoadBalancerDeletionProtection: "true",
+			},
+			want: &serviceAnnotations{RetainPolicy: RetainPolicyRetainIP, DeletionProtection: true},
+		},
+		{
+			name: "invalid adoption",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAdopt:        "Legacy_Web",
+				ServiceAnnotationLoadBalancerRetainPolicy: "keep",
+			},
+			wantErrs: []string{ServiceAnnotationLoadBalancerAdopt, ServiceAnnotationLoadBalancerRetainPolicy},
+		},
+		{
+			name:        "sharing key",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSharingKey: "dns"},
+			want:        &serviceAnnotations{SharingKey: "dns"},
+		},
+		{
+			name: "sharing key with adoption",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAdopt:      "legacy-web",
+				ServiceAnnotationLoadBalancerSharingKey: "dns",
+			},
+			wantErrs: []string{ServiceAnnotationLoadBalancerSharingKey},
+		},
+		{
+			name: "reserved IP with requested IP",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIP:         "203.0.113.20",
+				ServiceAnnotationLoadBalancerReservedIP: "web-ip",
+			},
+			wantErrs: []string{ServiceAnnotationLoadBalancerReservedIP},
+		},
+		{
+			name: "TLS",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerTLSCertificates: "web-tls, vcloud:cert-123",
+				ServiceAnnotationLoadBalancerTLSPorts:        "https",
+			},
+			want: &serviceAnnotations{TLS: &tlsOptions{
+				Certificates: []certificateRef{{Secret: "web-tls"}, {ID: "cert-123"}},
+				Ports:        []string{"https"},
+			}},
+		},
+		{
+			name: "invalid TLS",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerTLSCertificates: "Web_TLS,vcloud:",
+				ServiceAnnotationLoadBalancerTLSPorts:        "53,8443",
+			},
+			wantErrs: []string{`got "Web_TLS"`, `got "vcloud:"`, `port "53", TLS can only`, `port "8443", which is not`},
+		},
+		{
+			name:        "node selector",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNodeSelector: "role=ingress, pool in (edge,dmz)"},
+			want:        &serviceAnnotations{NodeSelector: "pool in (dmz,edge),role=ingress"},
+		},
+		{
+			name:        "zone policy",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerZonePolicy: "spread"},
+			want:        &serviceAnnotations{ZonePolicy: ZonePolicySpread},
+		},
+		{
+			name:        "invalid zone policy",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerZonePolicy: "nearest"},
+			wantErrs:    []string{ServiceAnnotationLoadBalancerZonePolicy},
+		},
+		{
+			name:        "invalid node selector",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNodeSelector: "role in (ingress"},
+			wantErrs:    []string{ServiceAnnotationLoadBalancerNodeSelector},
+		},
+		{
+			name:        "TLS ports without certificates",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerTLSPorts: "443"},
+			wantErrs:    []string{ServiceAnnotationLoadBalancerTLSPorts + " requires"},
+		},
+		{
+			name: "network and subnet",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerNetwork: "dmz",
+				ServiceAnnotationLoadBalancerSubnet:  "dmz-a",
+			},
+			want: &serviceAnnotations{Network: "dmz", Subnet: "dmz-a"},
+		},
+		{
+			name: "invalid network and subnet",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerNetwork: "DMZ",
+				ServiceAnnotationLoadBalancerSubnet:  "dmz_a",
+			},
+			wantErrs: []string{ServiceAnnotationLoadBalancerNetwork, ServiceAnnotationLoadBalancerSubnet},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: v1.ServiceSpec{
+					LoadBalancerIP: tt.loadBalancerIP,
+					Ports: []v1.ServicePort{
+						{Name: "https", Port: 443, Protocol: v1.ProtocolTCP},
+						{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+					},
+				},
+			}
+			got, err := parseServiceAnnotations(service)
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatalf("expected errors for %v, got nil", tt.wantErrs)
+				}
+				for _, want := range tt.wantErrs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("expected error mentioning %q, got %q", want, err.Error())
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected annotations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetSourceRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		specRanges []string
+		annotation string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name: "no ranges allows all",
+			want: []string{},
+		},
+		{
+			name:       "spec ranges are sorted",
+			specRanges: []string{"192.168.0.0/16", "10.0.0.0/8"},
+			want:       []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		{
+			name:       "legacy annotation",
+			annotation: "203.0.113.0/24, 198.51.100.0/24",
+			want:       []string{"198.51.100.0/24", "203.0.113.0/24"},
+		},
+		{
+			name:       "spec takes precedence over annotation",
+			specRanges: []string{"10.0.0.0/8"},
+			annotation: "203.0.113.0/24",
+			want:       []string{"10.0.0.0/8"},
+		},
+		{
+			name:       "allow all is no restriction",
+			specRanges: []string{"10.0.0.0/8", "0.0.0.0/0"},
+			want:       []string{},
+		},
+		{
+			name:       "invalid range",
+			specRanges: []string{"10.0.0.0/33"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+				Spec:       v1.ServiceSpec{LoadBalancerSourceRanges: tt.specRanges},
+			}
+			if tt.annotation != "" {
+				service.Annotations[v1.AnnotationLoadBalancerSourceRangesKey] = tt.annotation
+			}
+
+			got, err := getSourceRanges(service)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected source ranges (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuildHealthCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    v1.ServiceExternalTrafficPolicy
+		nodePort  int32
+		annotated *LoadBalancerHealthCheck
+		want      *LoadBalancerHealthCheck
+	}{
+		{
+			name:   "cluster policy defaults to tcp",
+			policy: v1.ServiceExternalTrafficPolicyCluster,
+			want:   &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolTCP},
+		},
+		{
+			name:      "cluster policy keeps annotated http check",
+			policy:    v1.ServiceExternalTrafficPolicyCluster,
+			annotated: &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolHTTP, Path: "/ready", Interval: 10},
+			want:      &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolHTTP, Path: "/ready", Interval: 10},
+		},
+		{
+			name:     "local policy checks health check node port",
+			policy:   v1.ServiceExternalTrafficPolicyLocal,
+			nodePort: 32000,
+			want:     &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolHTTP, Path: "/healthz", Port: 32000},
+		},
+		{
+			name:      "local policy keeps annotated timing",
+			policy:    v1.ServiceExternalTrafficPolicyLocal,
+			nodePort:  32000,
+			annotated: &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolTCP, Interval: 5, UnhealthyThreshold: 2},
+			want:      &LoadBalancerHealthCheck{Protocol: HealthCheckProtocolHTTP, Path: "/healthz", Port: 32000, Interval: 5, UnhealthyThreshold: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type:                  v1.ServiceTypeLoadBalancer,
+					ExternalTrafficPolicy: tt.policy,
+					HealthCheckNodePort:   tt.nodePort,
+				},
+			}
+			if diff := cmp.Diff(tt.want, buildHealthCheck(service, tt.annotated)); diff != "" {
+				t.Errorf("unexpected health check (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBindPublicIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/clusters/"+testClusterID+"/public-ips/") {
+		case "203.0.113.20":
+			fmt.Fprint(w, `{"status": 200, "data": {"publicIP": {"address": "203.0.113.20"}}}`)
+		case "203.0.113.21":
+			fmt.Fprint(w, `{"status": 200, "data": {"publicIP": {"address": "203.0.113.21", "ingress": "other-ingress"}}}`)
+		case "web-ip":
+			fmt.Fprint(w, `{"status": 200, "data": {"publicIP": {"name": "web-ip", "address": "203.0.113.22", "reserved": true, "ingress": "test-lb"}}}`)
+		case "pool-ip":
+			fmt.Fprint(w, `{"status": 200, "data": {"publicIP": {"name": "pool-ip", "address": "203.0.113.23"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"status": 404, "error": "Public IP not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		req        LoadBalancerRequest
+		wantIP     string
+		wantReason string
+	}{
+		{
+			name: "no IP requested",
+			req:  LoadBalancerRequest{Name: "test-lb"},
+		},
+		{
+			name:   "free requested IP",
+			req:    LoadBalancerRequest{Name: "test-lb", LoadBalancerIP: "203.0.113.20"},
+			wantIP: "203.0.113.20",
+		},
+		{
+			name:       "requested IP used by another ingress",
+			req:        LoadBalancerRequest{Name: "test-lb", LoadBalancerIP: "203.0.113.21"},
+			wantReason: eventReasonLoadBalancerIPUnavailable,
+		},
+		{
+			name:       "requested IP not in tenant pool",
+			req:        LoadBalancerRequest{Name: "test-lb", LoadBalancerIP: "198.51.100.1"},
+			wantReason: eventReasonLoadBalancerIPNotReserved,
+		},
+		{
+			name:   "reserved IP already bound to this load balancer",
+			req:    LoadBalancerRequest{Name: "test-lb", ReservedIP: "web-ip"},
+			wantIP: "203.0.113.22",
+		},
+		{
+			name:       "named IP that is not reserved",
+			req:        LoadBalancerRequest{Name: "test-lb", ReservedIP: "pool-ip"},
+			wantReason: eventReasonLoadBalancerIPNotReserved,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := createTestProvider(t)
+			provider.mgmtURL = server.URL
+			recorder := record.NewFakeRecorder(10)
+			provider.recorder = recorder
+			lb := provider.loadbalancer.(*VCloudLoadBalancer)
+
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+			req := tt.req
+			err := lb.bindPublicIP(context.Background(), service, &req)
+
+			if tt.wantReason != "" {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				select {
+				case event := <-recorder.Events:
+					if !strings.Contains(event, tt.wantReason) {
+						t.Errorf("expected %s event, got %q", tt.wantReason, event)
+					}
+				default:
+					t.Errorf("expected %s event, got none", tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.LoadBalancerIP != tt.wantIP {
+				t.Errorf("expected load balancer IP %q, got %q", tt.wantIP, req.LoadBalancerIP)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+func createTestProvider(t *testing.T) *VCloudProvider {
+	config := `[vCloud]
+CLUSTER_ID = d73c6df2-f7fe-4f7c-bf70-9f94cce26430
+CLUSTER_NAME = test-cluster
+MGMT_URL = https://api.vcloud.example.com
+PROVIDER_TOKEN = test-token`
+
+	provider, err := NewVCloudProvider(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("failed to create test provider: %v", err)
+	}
+
+	return provider.(*VCloudProvider)
+}
+
+// generateTestCertificate returns a PEM encoded self-signed certificate and key for the common name
+func generateTestCertificate(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
 }
 
 func intstrFromInt(val int) intstr.IntOrString {
@@ -0,0 +1,307 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// watchRetryPeriod is the delay before reconnecting a closed instance watch
+	watchRetryPeriod = 5 * time.Second
+
+	// maxWatchEventSize limits the size of a single watch event
+	maxWatchEventSize = 1024 * 1024
+
+	// watchIdleTimeout reconnects a watch that received neither an event nor a keep-alive for that long,
+	// the connection being half-open
+	watchIdleTimeout = 2 * time.Minute
+
+	// Connection timeouts of the watch, which has no overall timeout
+	watchDialTimeout           = 30 * time.Second
+	watchTLSHandshakeTimeout   = 10 * time.Second
+	watchResponseHeaderTimeout = 30 * time.Second
+
+	// Instance watch event types
+	watchEventAdded    = "ADDED"
+	watchEventModified = "MODIFIED"
+	watchEventDeleted  = "DELETED"
+	watchEventBookmark = "BOOKMARK"
+)
+
+// instanceState is the part of an instance that node controllers act upon
+type instanceState struct {
+	exists   bool
+	shutdown bool
+}
+
+// watchEvent is a single Server-Sent Event of the instance watch
+type watchEvent struct {
+	id        string
+	eventType string
+	data      string
+}
+
+// instanceWatcher keeps the instance cache current from a Server-Sent Events stream
+type instanceWatcher struct {
+	provider    *VCloudProvider
+	cache       *instanceCache
+	httpClient  *http.Client
+	idleTimeout time.Duration
+
+	// resourceVersion is the version to resume the watch from
+	resourceVersion string
+	// states holds the last seen state of each existing instance
+	states map[string]instanceState
+	// synced is set once the initial events of the watch have established the known state
+	synced bool
+}
+
+// newInstanceWatcher creates a new instance watcher
+func newInstanceWatcher(provider *VCloudProvider, cache *instanceCache) *instanceWatcher {
+	return &instanceWatcher{
+		provider: provider,
+		cache:    cache,
+		// No overall timeout, the stream stays open until the server, the idle timeout or the stop channel
+		// closes it
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: watchDialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   watchTLSHandshakeTimeout,
+				ResponseHeaderTimeout: watchResponseHeaderTimeout,
+			},
+		},
+		idleTimeout: watchIdleTimeout,
+		states:      make(map[string]instanceState),
+	}
+}
+
+// idleTimeoutReader postpones its timer with every read, so the timer only fires once the stream stalls
+type idleTimeoutReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// Run watches instances until the stop channel is closed, reconnecting after disconnects
+func (w *instanceWatcher) Run(stop <-chan struct{}) {
+	klog.Infof("Starting vcloud instance watch")
+	ctx := wait.ContextForChannel(stop)
+	wait.Until(func() {
+		if err := w.watch(ctx); err != nil {
+			klog.Errorf("Instance watch failed, reconnecting in %v: %v", watchRetryPeriod, err)
+			return
+		}
+		klog.V(3).Infof("Instance watch closed, reconnecting from resource version %q", w.resourceVersion)
+	}, watchRetryPeriod, stop)
+	klog.Infof("Stopped vcloud instance watch")
+}
+
+// watch opens a single watch stream and processes its events until it closes
+func (w *instanceWatcher) watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("watch", "true")
+	if w.resourceVersion != "" {
+		query.Set("resourceVersion", w.resourceVersion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", w.provider.apiURL("/instances?"+query.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Provider-Token", w.provider.providerToken)
+	req.Header.Set("Accept", "text/event-stream")
+	if w.resourceVersion != "" {
+		req.Header.Set("Last-Event-ID", w.resourceVersion)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The resource version is too old to resume from, start over with the current state
+	if resp.StatusCode == http.StatusGone {
+		klog.Warningf("Instance watch resource version %q expired, restarting watch", w.resourceVersion)
+		w.resourceVersion = ""
+		// The restarted watch lists the existing instances again
+		w.states = make(map[string]instanceState)
+		w.synced = false
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	klog.V(2).Infof("Instance watch connected (resourceVersion=%q)", w.resourceVersion)
+	var idle atomic.Bool
+	timer := time.AfterFunc(w.idleTimeout, func() {
+		idle.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	err = w.readEvents(ctx, &idleTimeoutReader{reader: resp.Body, timer: timer, timeout: w.idleTimeout})
+	if idle.Load() {
+		return fmt.Errorf("no event or keep-alive received for %v", w.idleTimeout)
+	}
+	w.markSynced()
+	return err
+}
+
+// markSynced records that the initial events of the watch have been received, so instances seen for the
+// first time from now on are new
+func (w *instanceWatcher) markSynced() {
+	if !w.synced {
+		klog.V(3).Infof("Instance watch synced with %d known instances", len(w.states))
+		w.synced = true
+	}
+}
+
+// readEvents parses the Server-Sent Events stream and handles each event
+func (w *instanceWatcher) readEvents(ctx context.Context, body io.Reader) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxWatchEventSize)
+
+	var event watchEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line dispatches the event
+		if line == "" {
+			if event.data != "" || event.eventType != "" {
+				w.handleEvent(ctx, event)
+			}
+			event = watchEvent{}
+			continue
+		}
+
+		// Lines starting with a colon are comments, used as keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.eventType = value
+		case "data":
+			if event.data != "" {
+				event.data += "\n"
+			}
+			event.data += value
+		}
+	}
+
+	return scanner.Err()
+}
+
+// handleEvent updates the cache from a watch event and requeues the node on state changes
+func (w *instanceWatcher) handleEvent(ctx context.Context, event watchEvent) {
+	defer func() {
+		if event.id != "" {
+			w.resourceVersion = event.id
+		}
+	}()
+
+	// The first bookmark ends the initial events
+	if event.eventType == watchEventBookmark {
+		w.markSynced()
+		return
+	}
+
+	var data struct {
+		Instance Instance `json:"instance"`
+	}
+	if err := json.Unmarshal([]byte(event.data), &data); err != nil {
+		klog.Errorf("Failed to decode instance watch event %q: %v", event.id, err)
+		return
+	}
+
+	instanceID := data.Instance.ID
+	if instanceID == "" {
+		klog.Warningf("Ignoring instance watch event %q without instance ID", event.id)
+		return
+	}
+
+	var info *InstanceInfo
+	switch event.eventType {
+	case watchEventAdded, watchEventModified:
+		info = newInstanceInfo(instanceID, &data.Instance)
+	case watchEventDeleted:
+		info = &InstanceInfo{Exists: false, RawInstance: &data.Instance}
+	default:
+		klog.V(4).Infof("Ignoring instance watch event of type %q", event.eventType)
+		return
+	}
+
+	klog.V(4).Infof("Instance watch: %s instance %s (exists=%t, shutdown=%t)", event.eventType, instanceID, info.Exists, info.Shutdown)
+	w.cache.set(instanceID, info)
+
+	state := instanceState{exists: info.Exists, shutdown: info.Shutdown}
+	previous, seen := w.states[instanceID]
+	if state.exists {
+		w.states[instanceID] = state
+	} else {
+		delete(w.states, instanceID)
+	}
+
+	// The initial events only establish the known state, later ones requeue the node of an instance that
+	// appears, comes back after its deletion, disappears or changes shutdown state
+	if seen && previous == state {
+		return
+	}
+	if !seen && (!w.synced || !state.exists) {
+		return
+	}
+
+	klog.V(2).Infof("Instance %s changed state (exists=%t, shutdown=%t)", instanceID, state.exists, state.shutdown)
+	if err := w.provider.requeueInstanceNode(instanceID); err != nil {
+		klog.Errorf("Failed to requeue node of instance %s: %v", instanceID, err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcloud
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxBackendWeight caps the weight of a backend, the mgmt API accepts weights up to 256
+	maxBackendWeight = 256
+
+	// spreadZoneWeight is the total weight of the backends of a zone when spreading evenly
+	spreadZoneWeight = 100
+)
+
+// Zone policies balancing traffic between the zones of the backends
+const (
+	// ZonePolicyPreferSameZone sends traffic to backends in the zone of the frontend while any is healthy
+	ZonePolicyPreferSameZone = "PreferSameZone"
+	// ZonePolicySpread gives every zone the same share of the traffic, whatever its number of backends
+	ZonePolicySpread = "Spread"
+)
+
+// nodeZone returns the zone of the node from its topology labels, "" if unknown
+func nodeZone(node *v1.Node) string {
+	if zone := node.Labels[v1.LabelTopologyZone]; zone != "" {
+		return zone
+	}
+	return node.Labels[v1.LabelFailureDomainBetaZone]
+}
+
+// nodeCapacity returns the CPU cores of the node, which follow its flavor, 0 if not reported yet
+func nodeCapacity(node *v1.Node) int64 {
+	cpu, ok := node.Status.Capacity[v1.ResourceCPU]
+	if !ok {
+		return 0
+	}
+	return cpu.Value()
+}
+
+// backendWeights returns the weight of every node. Weights follow the node capacity; nodes that have not
+// reported it get no weight, so the mgmt API default applies. With the Spread policy the weights of each
+// zone add up to spreadZoneWeight, nodes without capacity counting as one core.
+func backendWeights(nodes []*v1.Node, zonePolicy string) map[string]int32 {
+	weights := make(map[string]int32, len(nodes))
+	if zonePolicy != ZonePolicySpread {
+		for _, node := range nodes {
+			weights[node.Name] = int32(min(nodeCapacity(node), maxBackendWeight))
+		}
+		return weights
+	}
+
+	zoneCapacity := make(map[string]int64)
+	for _, node := range nodes {
+		zoneCapacity[nodeZone(node)] += max(nodeCapacity(node), 1)
+	}
+	for _, node := range nodes {
+		capacity, total := max(nodeCapacity(node), 1), zoneCapacity[nodeZone(node)]
+		weights[node.Name] = int32(max((capacity*spreadZoneWeight+total/2)/total, 1))
+	}
+	return weights
+}
+
+// setBackendTopology sets the zone and weight of the backends from their nodes
+func setBackendTopology(backends []LoadBalancerBackend, nodes []*v1.Node, zonePolicy string) {
+	zones := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		zones[node.Name] = nodeZone(node)
+	}
+	weights := backendWeights(nodes, zonePolicy)
+
+	for i := range backends {
+		backends[i].Zone = zones[backends[i].Node]
+		backends[i].Weight = weights[backends[i].Node]
+	}
+}